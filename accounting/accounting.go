@@ -18,6 +18,7 @@ package accounting
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 
 	//"github.com/algorand/go-algorand-sdk/encoding/json"
@@ -41,41 +42,108 @@ type AccountingState struct {
 
 	rewardsLevel uint64
 
-	// number of txns at the end of the previous block
+	// number of txns at the end of the previous block, new asset ids are derived from it
 	txnCounter uint64
+	// TxnCounter of the current block
+	roundTxnCounter uint64
+
+	// in-memory state has been loaded from the db, see restore()
+	restored bool
 }
 
 func New(db idb.IndexerDb) *AccountingState {
 	return &AccountingState{db: db, defaultFrozen: make(map[uint64]bool)}
 }
 
-func foo(db idb.IndexerDb) {
-	//var defaultFrozen map[uint64]bool
-}
-
 func (accounting *AccountingState) initRound(round uint64) error {
 	block, err := accounting.db.GetBlock(round)
 	if err != nil {
 		return err
 	}
-	accounting.feeAddr = block.FeeSink
-	accounting.rewardAddr = block.RewardsPool
-	accounting.rewardsLevel = block.RewardsLevel
+	return accounting.setRoundHeader(block.BlockHeader)
+}
+
+func (accounting *AccountingState) setRoundHeader(header types.BlockHeader) (err error) {
+	round := uint64(header.Round)
+	if accounting.restored {
+		// rounds skipped since the last one have no txns and didn't move the counter
+		accounting.txnCounter = accounting.roundTxnCounter
+	} else {
+		err = accounting.restore(round)
+		if err != nil {
+			return
+		}
+	}
+	accounting.roundTxnCounter = header.TxnCounter
+	accounting.RoundUpdates.TxnCounter = header.TxnCounter
+	accounting.feeAddr = header.FeeSink
+	accounting.rewardAddr = header.RewardsPool
+	accounting.rewardsLevel = header.RewardsLevel
 	accounting.currentRound = round
 	return nil
 }
 
+// restore loads what accounting keeps in memory from the db before accounting for round,
+// so that resuming at any round gives the same results as never having stopped.
+// Everything else accounting uses (fee sink, rewards pool, rewards level) comes from each round's block header.
+func (accounting *AccountingState) restore(round uint64) (err error) {
+	accounting.defaultFrozen, err = accounting.db.GetDefaultFrozen()
+	if err != nil {
+		return fmt.Errorf("default frozen, %v", err)
+	}
+	accounting.txnCounter, err = accounting.loadTxnCounter(round)
+	if err != nil {
+		return fmt.Errorf("txn counter before round %d, %v", round, err)
+	}
+	accounting.restored = true
+	return nil
+}
+
+// loadTxnCounter gets the TxnCounter at the end of the round before round.
+// That's in its block header, or if that block isn't in the db (e.g. after bootstrap-accounts) in what accounting last committed.
+// Asset ids would silently come out wrong from a guessed counter, so it's an error to have neither.
+func (accounting *AccountingState) loadTxnCounter(round uint64) (txnCounter uint64, err error) {
+	if round == 0 {
+		return 0, nil
+	}
+	prev, err := accounting.db.GetBlock(round - 1)
+	if err == nil {
+		return prev.TxnCounter, nil
+	}
+	if err != sql.ErrNoRows {
+		return
+	}
+	stateJsonStr, err := accounting.db.GetMetastate("state")
+	if err != nil {
+		return
+	}
+	if stateJsonStr == "" {
+		return 0, fmt.Errorf("no block %d and no accounting state to get it from", round-1)
+	}
+	istate, err := idb.ParseImportState(stateJsonStr)
+	if err != nil {
+		return
+	}
+	if istate.AccountRound < 0 || uint64(istate.AccountRound) >= round {
+		return 0, fmt.Errorf("no block %d and accounting is through round %d", round-1, istate.AccountRound)
+	}
+	if istate.TxnCounter == 0 && istate.AccountRound > 0 {
+		// state from bootstrap-accounts before it recorded the counter
+		return 0, fmt.Errorf("no block %d and no txn counter recorded for round %d, re-run bootstrap-accounts with --txn-counter", round-1, istate.AccountRound)
+	}
+	return istate.TxnCounter, nil
+}
+
 func (accounting *AccountingState) commitRound() error {
+	if !accounting.restored {
+		// no round started yet
+		return nil
+	}
 	err := accounting.db.CommitRoundAccounting(accounting.RoundUpdates, accounting.currentRound, accounting.rewardsLevel)
 	if err != nil {
 		return err
 	}
-	accounting.AlgoUpdates = nil
-	accounting.AssetUpdates = nil
-	accounting.AcfgUpdates = nil
-	accounting.FreezeUpdates = nil
-	accounting.AssetCloses = nil
-	accounting.AssetDestroys = nil
+	accounting.RoundUpdates = idb.RoundUpdates{}
 	return nil
 }
 
@@ -83,6 +151,26 @@ func (accounting *AccountingState) Close() error {
 	return accounting.commitRound()
 }
 
+// AddBlock accounts for a block that's already in memory.
+// The changes are returned instead of committed so they can be written in the same db transaction as the block.
+// Don't mix with AddTransaction() within a round.
+func (accounting *AccountingState) AddBlock(header types.BlockHeader, payset []types.SignedTxnInBlock) (updates idb.RoundUpdates, err error) {
+	round := uint64(header.Round)
+	err = accounting.setRoundHeader(header)
+	if err != nil {
+		return
+	}
+	for intra, stxn := range payset {
+		err = accounting.addTxn(round, intra, stxn)
+		if err != nil {
+			return
+		}
+	}
+	updates = accounting.RoundUpdates
+	accounting.RoundUpdates = idb.RoundUpdates{}
+	return
+}
+
 var zeroAddr = [32]byte{}
 
 func addrIsZero(a types.Address) bool {
@@ -110,6 +198,27 @@ func (accounting *AccountingState) destroyAsset(assetId uint64) {
 	accounting.AssetDestroys = append(accounting.AssetDestroys, assetId)
 }
 
+// keyreg is go-algorand's keyreg apply: going online with keys, offline without them, or never participating again.
+func (accounting *AccountingState) keyreg(addr types.Address, txn types.Transaction) {
+	ku := idb.KeyregUpdate{Addr: addr}
+	kr := txn.KeyregTxnFields
+	votePK := types.OneTimeSignatureVerifier(kr.VotePK)
+	selectionPK := types.VRFVerifier(kr.SelectionPK)
+	if txn.Nonparticipation {
+		ku.Status = types.NotParticipating
+	} else if votePK == (types.OneTimeSignatureVerifier{}) || selectionPK == (types.VRFVerifier{}) {
+		ku.Status = types.Offline
+	} else {
+		ku.Status = types.Online
+		ku.VoteID = votePK
+		ku.SelectionID = selectionPK
+		ku.VoteFirstValid = types.Round(kr.VoteFirst)
+		ku.VoteLastValid = types.Round(kr.VoteLast)
+		ku.VoteKeyDilution = kr.VoteKeyDilution
+	}
+	accounting.KeyregUpdates = append(accounting.KeyregUpdates, ku)
+}
+
 func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnbytes []byte) (err error) {
 	var stxn types.SignedTxnInBlock
 	err = msgpack.Decode(txnbytes, &stxn)
@@ -126,7 +235,10 @@ func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnby
 			return fmt.Errorf("add tx init round %d, %v", round, err)
 		}
 	}
+	return accounting.addTxn(round, intra, stxn)
+}
 
+func (accounting *AccountingState) addTxn(round uint64, intra int, stxn types.SignedTxnInBlock) (err error) {
 	accounting.updateAlgo(stxn.Txn.Sender, -int64(stxn.Txn.Fee))
 	accounting.updateAlgo(accounting.feeAddr, int64(stxn.Txn.Fee))
 
@@ -145,6 +257,9 @@ func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnby
 			accounting.updateAlgo(stxn.Txn.Sender, -int64(stxn.ClosingAmount))
 			accounting.updateAlgo(stxn.Txn.CloseRemainderTo, int64(stxn.ClosingAmount))
 		}
+		if !addrIsZero(stxn.Txn.CloseRemainderTo) {
+			accounting.AlgoCloses = append(accounting.AlgoCloses, stxn.Txn.Sender)
+		}
 		if stxn.ReceiverRewards != 0 {
 			accounting.updateAlgo(stxn.Txn.Receiver, int64(stxn.ReceiverRewards))
 			accounting.updateAlgo(accounting.rewardAddr, -int64(stxn.ReceiverRewards))
@@ -154,13 +269,13 @@ func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnby
 			accounting.updateAlgo(accounting.rewardAddr, -int64(stxn.CloseRewards))
 		}
 	} else if stxn.Txn.Type == "keyreg" {
-		// TODO: record keys?
+		accounting.keyreg(stxn.Txn.Sender, stxn.Txn)
 	} else if stxn.Txn.Type == "acfg" {
 		assetId := uint64(stxn.Txn.ConfigAsset)
 		if assetId == 0 {
 			assetId = accounting.txnCounter + uint64(intra) + 1
 		}
-		if stxn.Txn.AssetParams.IsZero() {
+		if stxn.Txn.AssetParams == (types.AssetParams{}) {
 			accounting.destroyAsset(assetId)
 		} else {
 			accounting.AcfgUpdates = append(accounting.AcfgUpdates, idb.AcfgUpdate{AssetId: assetId, Creator: stxn.Txn.Sender, Params: stxn.Txn.AssetParams})
@@ -174,14 +289,14 @@ func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnby
 		}
 	} else if stxn.Txn.Type == "axfer" {
 		sender := stxn.Txn.AssetSender // closeout
-		if sender.IsZero() {
+		if addrIsZero(sender) {
 			sender = stxn.Txn.Sender
 		}
 		if stxn.Txn.AssetAmount != 0 {
 			accounting.updateAsset(sender, uint64(stxn.Txn.XferAsset), -int64(stxn.Txn.AssetAmount))
 			accounting.updateAsset(stxn.Txn.AssetReceiver, uint64(stxn.Txn.XferAsset), int64(stxn.Txn.AssetAmount))
 		}
-		if !stxn.Txn.AssetCloseTo.IsZero() {
+		if !addrIsZero(stxn.Txn.AssetCloseTo) {
 			accounting.closeAsset(sender, uint64(stxn.Txn.XferAsset), stxn.Txn.AssetCloseTo)
 		}
 	} else if stxn.Txn.Type == "afrz" {
@@ -191,5 +306,3 @@ func (accounting *AccountingState) AddTransaction(round uint64, intra int, txnby
 	}
 	return nil
 }
-
-// TODO: copy rewards calculation logic from go-algorand so that we can present full balance including unrealized rewards.
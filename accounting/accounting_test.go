@@ -0,0 +1,370 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package accounting
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	atypes "github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+// fakeDb has block headers by round and a metastate "state", and records committed rounds
+type fakeDb struct {
+	idb.IndexerDb
+
+	txnCounters map[uint64]uint64
+	state       string
+	committed   []idb.RoundUpdates
+	// crashRound is a round whose commit fails, if crash
+	crash      bool
+	crashRound uint64
+}
+
+func (db *fakeDb) GetBlock(round uint64) (block types.Block, err error) {
+	tc, ok := db.txnCounters[round]
+	if !ok {
+		return block, sql.ErrNoRows
+	}
+	block.Round = types.Round(round)
+	block.TxnCounter = tc
+	return block, nil
+}
+
+func (db *fakeDb) GetMetastate(key string) (string, error) {
+	if key != "state" {
+		return "", nil
+	}
+	return db.state, nil
+}
+
+func (db *fakeDb) GetDefaultFrozen() (map[uint64]bool, error) {
+	return make(map[uint64]bool), nil
+}
+
+func (db *fakeDb) CommitRoundAccounting(updates idb.RoundUpdates, round, rewardsBase uint64) error {
+	if db.crash && round == db.crashRound {
+		return errors.New("connection lost")
+	}
+	db.committed = append(db.committed, updates)
+	db.state = testState(int64(round), updates.TxnCounter)
+	return nil
+}
+
+// testRound is a block of pay and acfg creation txns, "pay acfg" etc
+type testRound struct {
+	round      uint64
+	txnCounter uint64
+	txns       []string
+}
+
+func (tr testRound) header() types.BlockHeader {
+	return types.BlockHeader{Round: types.Round(tr.round), TxnCounter: tr.txnCounter}
+}
+
+func (tr testRound) payset() []types.SignedTxnInBlock {
+	payset := make([]types.SignedTxnInBlock, len(tr.txns))
+	for i, txtype := range tr.txns {
+		stxn := &payset[i]
+		stxn.Txn.Sender[0] = 1
+		stxn.Txn.Type = atypes.TxType(txtype)
+		if txtype == "acfg" {
+			stxn.Txn.AssetParams = types.AssetParams{Total: 100, UnitName: "T"}
+		} else {
+			stxn.Txn.Receiver[0] = 2
+			stxn.Txn.Amount = 1
+		}
+	}
+	return payset
+}
+
+func assetIds(updates []idb.RoundUpdates) (ids []uint64) {
+	for _, ru := range updates {
+		for _, au := range ru.AcfgUpdates {
+			ids = append(ids, au.AssetId)
+		}
+	}
+	return
+}
+
+func testState(accountRound int64, txnCounter uint64) string {
+	return fmt.Sprintf(`{"account_round":%d,"txn_counter":%d}`, accountRound, txnCounter)
+}
+
+func TestAssetIds(t *testing.T) {
+	tests := []struct {
+		name string
+		// block headers already in the db, round: TxnCounter
+		txnCounters map[uint64]uint64
+		state       string
+		rounds      []testRound
+		want        []uint64
+	}{
+		{
+			name:        "first creation after genesis",
+			txnCounters: map[uint64]uint64{0: 0},
+			rounds:      []testRound{{1, 1, []string{"acfg"}}},
+			want:        []uint64{1},
+		},
+		{
+			name:        "several creations in one block",
+			txnCounters: map[uint64]uint64{0: 0},
+			rounds:      []testRound{{1, 5, []string{"pay", "acfg", "pay", "acfg", "acfg"}}},
+			want:        []uint64{2, 4, 5},
+		},
+		{
+			name:        "creations in consecutive blocks",
+			txnCounters: map[uint64]uint64{0: 0},
+			rounds: []testRound{
+				{1, 2, []string{"acfg", "pay"}},
+				{2, 4, []string{"pay", "acfg"}},
+			},
+			want: []uint64{1, 4},
+		},
+		{
+			name:        "skipped empty rounds",
+			txnCounters: map[uint64]uint64{0: 0},
+			rounds: []testRound{
+				{1, 2, []string{"pay", "pay"}},
+				// rounds 2 through 4 had no txns
+				{5, 4, []string{"pay", "acfg"}},
+			},
+			want: []uint64{4},
+		},
+		{
+			name:        "resume after restart",
+			txnCounters: map[uint64]uint64{0: 0, 1: 3, 2: 3, 3: 7},
+			state:       testState(3, 7),
+			rounds:      []testRound{{4, 9, []string{"acfg", "acfg"}}},
+			want:        []uint64{8, 9},
+		},
+		{
+			name:        "resume after restart past empty rounds",
+			txnCounters: map[uint64]uint64{0: 0, 1: 3, 2: 3, 3: 3},
+			state:       testState(1, 3),
+			rounds:      []testRound{{4, 5, []string{"pay", "acfg"}}},
+			want:        []uint64{5},
+		},
+		{
+			name:   "resume after bootstrap",
+			state:  testState(9, 1000),
+			rounds: []testRound{{10, 1003, []string{"pay", "acfg", "acfg"}}},
+			want:   []uint64{1002, 1003},
+		},
+		{
+			name:   "resume after bootstrap past empty rounds",
+			state:  testState(9, 1000),
+			rounds: []testRound{{10, 1000, nil}, {11, 1000, nil}, {12, 1001, []string{"acfg"}}},
+			want:   []uint64{1001},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDb{txnCounters: tc.txnCounters, state: tc.state}
+			acct := New(db)
+			var updates []idb.RoundUpdates
+			for _, tr := range tc.rounds {
+				ru, err := acct.AddBlock(tr.header(), tr.payset())
+				if err != nil {
+					t.Fatal(err)
+				}
+				updates = append(updates, ru)
+			}
+			got := assetIds(updates)
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("asset ids %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// AddTransaction reads each round's header from the db and commits the previous round when the round changes
+func TestAssetIdsAddTransaction(t *testing.T) {
+	rounds := []testRound{
+		{1, 2, []string{"acfg", "pay"}},
+		{3, 4, []string{"acfg", "acfg"}},
+	}
+	db := &fakeDb{txnCounters: map[uint64]uint64{0: 0, 1: 2, 2: 2, 3: 4}}
+	acct := New(db)
+	for _, tr := range rounds {
+		for intra, stxn := range tr.payset() {
+			err := acct.AddTransaction(tr.round, intra, msgpack.Encode(stxn))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := acct.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := assetIds(db.committed)
+	if fmt.Sprint(got) != "[1 3 4]" {
+		t.Fatalf("asset ids %v, want [1 3 4]", got)
+	}
+}
+
+func TestTxnCounterUnknown(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string
+	}{
+		{"no block and no state", ""},
+		{"bootstrap without a txn counter", testState(9, 0)},
+		{"state after the round", testState(12, 1000)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			acct := New(&fakeDb{state: tc.state})
+			tr := testRound{10, 1001, []string{"acfg"}}
+			_, err := acct.AddBlock(tr.header(), tr.payset())
+			if err == nil {
+				t.Fatal("accounted without knowing the txn counter")
+			}
+		})
+	}
+}
+
+func TestKeyreg(t *testing.T) {
+	var votePK atypes.VotePK
+	var selectionPK atypes.VRFPK
+	votePK[0] = 7
+	selectionPK[0] = 8
+	tests := []struct {
+		name    string
+		votePK  atypes.VotePK
+		selPK   atypes.VRFPK
+		nonpart bool
+		status  byte
+	}{
+		{"online", votePK, selectionPK, false, types.Online},
+		{"offline", atypes.VotePK{}, atypes.VRFPK{}, false, types.Offline},
+		{"nonparticipating", atypes.VotePK{}, atypes.VRFPK{}, true, types.NotParticipating},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stxn types.SignedTxnInBlock
+			stxn.Txn.Type = atypes.KeyRegistrationTx
+			stxn.Txn.Sender[0] = 1
+			stxn.Txn.VotePK = tc.votePK
+			stxn.Txn.SelectionPK = tc.selPK
+			stxn.Txn.VoteFirst = 1
+			stxn.Txn.VoteLast = 1000
+			stxn.Txn.VoteKeyDilution = 10
+			stxn.Txn.Nonparticipation = tc.nonpart
+			// as stored in and read from the db
+			txnbytes := msgpack.Encode(stxn)
+			db := &fakeDb{txnCounters: map[uint64]uint64{0: 0, 1: 1}}
+			acct := New(db)
+			err := acct.AddTransaction(1, 0, txnbytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = acct.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			updates := db.committed[len(db.committed)-1]
+			if len(updates.KeyregUpdates) != 1 {
+				t.Fatalf("keyreg updates %v", updates.KeyregUpdates)
+			}
+			ku := updates.KeyregUpdates[0]
+			if ku.Status != tc.status {
+				t.Fatalf("status %d, want %d", ku.Status, tc.status)
+			}
+			online := tc.status == types.Online
+			if (ku.VoteID != types.OneTimeSignatureVerifier{}) != online || (ku.VoteLastValid != 0) != online {
+				t.Fatalf("keys %x last valid %d for status %d", ku.VoteID[:], ku.VoteLastValid, ku.Status)
+			}
+
+			// the in-memory ledger indexer verify checks the db against
+			ledger := NewLedger()
+			ledger.Accounts[stxn.Txn.Sender] = &LedgerAccount{Data: types.AccountData{Status: types.Online, MicroAlgos: 1000}}
+			ledger.Apply(updates, 1, 0)
+			if status := ledger.Accounts[stxn.Txn.Sender].Data.Status; status != tc.status {
+				t.Fatalf("ledger status %d, want %d", status, tc.status)
+			}
+		})
+	}
+}
+
+// a commit that fails part way through a run is redone by the next run from the last committed round
+func TestResumeAfterCrash(t *testing.T) {
+	rounds := []testRound{
+		{1, 2, []string{"acfg", "pay"}},
+		{2, 4, []string{"pay", "acfg"}},
+		{3, 5, []string{"acfg"}},
+	}
+	db := &fakeDb{txnCounters: map[uint64]uint64{0: 0, 1: 2, 2: 4, 3: 5}, crash: true, crashRound: 2}
+	// txns after the last committed round, as YieldTxns gives them
+	run := func(acct *AccountingState, after int64) error {
+		for _, tr := range rounds {
+			if int64(tr.round) <= after {
+				continue
+			}
+			for intra, stxn := range tr.payset() {
+				err := acct.AddTransaction(tr.round, intra, msgpack.Encode(stxn))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return acct.Close()
+	}
+	err := run(New(db), -1)
+	if err == nil {
+		t.Fatal("crash in round 2 not returned")
+	}
+	istate, err := idb.ParseImportState(db.state)
+	if err != nil || istate.AccountRound != 1 {
+		t.Fatalf("committed through %d %v, want 1", istate.AccountRound, err)
+	}
+
+	db.crash = false
+	err = run(New(db), istate.AccountRound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := assetIds(db.committed)
+	if fmt.Sprint(got) != "[1 4 5]" {
+		t.Fatalf("asset ids %v, want [1 4 5]", got)
+	}
+	istate, err = idb.ParseImportState(db.state)
+	if err != nil || istate.AccountRound != 3 || istate.TxnCounter != 5 {
+		t.Fatalf("state %s %v", db.state, err)
+	}
+}
+
+// after restart without the previous block in the db, the counter comes from the committed state
+func TestResumeAfterCrashWithoutBlocks(t *testing.T) {
+	db := &fakeDb{state: testState(1, 2), txnCounters: map[uint64]uint64{3: 5}}
+	acct := New(db)
+	tr := testRound{3, 5, []string{"pay", "acfg"}}
+	updates, err := acct.AddBlock(tr.header(), tr.payset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := assetIds([]idb.RoundUpdates{updates}); fmt.Sprint(got) != "[4]" {
+		t.Fatalf("asset ids %v, want [4]", got)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package accounting
+
+import (
+	"fmt"
+
+	atypes "github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+// LedgerAccount is an account in a Ledger
+type LedgerAccount struct {
+	// Data has everything, including MicroAlgos, RewardsBase, Assets and AssetParams
+	Data types.AccountData
+	// Deleted is true for an account that was closed and not reopened
+	Deleted bool
+}
+
+// Ledger is account state held in memory.
+// Apply() changes it the same way the postgres db changes account, account_asset and asset, so that the two can be compared.
+type Ledger struct {
+	Accounts map[types.Address]*LedgerAccount
+
+	// creators of every asset ever created, destroyed asset params stay like they do in the db
+	creators map[uint64]types.Address
+
+	// OnChange is called for each account a round changed, after the whole round is applied
+	OnChange func(round uint64, addr types.Address, acct *LedgerAccount)
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		Accounts: make(map[types.Address]*LedgerAccount),
+		creators: make(map[uint64]types.Address),
+	}
+}
+
+// LoadGenesis sets the accounts in genesis
+func (ledger *Ledger) LoadGenesis(genesis types.Genesis) error {
+	for ai, alloc := range genesis.Allocation {
+		addr, err := atypes.DecodeAddress(alloc.Address)
+		if err != nil {
+			return fmt.Errorf("genesis account[%d] bad address %#v, %v", ai, alloc.Address, err)
+		}
+		acct := &LedgerAccount{Data: alloc.State}
+		acct.Data.Assets = make(map[types.AssetIndex]types.AssetHolding, len(alloc.State.Assets))
+		for assetid, holding := range alloc.State.Assets {
+			acct.Data.Assets[assetid] = holding
+		}
+		acct.Data.AssetParams = make(map[types.AssetIndex]types.AssetParams, len(alloc.State.AssetParams))
+		for assetid, params := range alloc.State.AssetParams {
+			acct.Data.AssetParams[assetid] = params
+			ledger.creators[uint64(assetid)] = addr
+		}
+		ledger.Accounts[addr] = acct
+	}
+	return nil
+}
+
+// DefaultFrozen is {assetid: default frozen, ...} for all assets
+func (ledger *Ledger) DefaultFrozen() map[uint64]bool {
+	defaultFrozen := make(map[uint64]bool, len(ledger.creators))
+	for assetid, creator := range ledger.creators {
+		defaultFrozen[assetid] = ledger.Accounts[creator].Data.AssetParams[types.AssetIndex(assetid)].DefaultFrozen
+	}
+	return defaultFrozen
+}
+
+func (ledger *Ledger) account(addr types.Address) *LedgerAccount {
+	acct, ok := ledger.Accounts[addr]
+	if !ok {
+		acct = &LedgerAccount{}
+		acct.Data.Assets = make(map[types.AssetIndex]types.AssetHolding)
+		acct.Data.AssetParams = make(map[types.AssetIndex]types.AssetParams)
+		ledger.Accounts[addr] = acct
+	}
+	return acct
+}
+
+// clearParticipation is what closing an account or going offline does to its keys
+func clearParticipation(ad *types.AccountData) {
+	ad.Status = types.Offline
+	ad.VoteID = types.OneTimeSignatureVerifier{}
+	ad.SelectionID = types.VRFVerifier{}
+	ad.VoteFirstValid = 0
+	ad.VoteLastValid = 0
+	ad.VoteKeyDilution = 0
+}
+
+// Apply applies a round's changes in the same order and with the same semantics as the postgres applyRoundAccounting
+func (ledger *Ledger) Apply(updates idb.RoundUpdates, round, rewardsBase uint64) {
+	changed := make(map[types.Address]bool)
+	for addr, delta := range updates.AlgoUpdates {
+		acct := ledger.account(addr)
+		acct.Data.MicroAlgos = types.MicroAlgos(int64(acct.Data.MicroAlgos) + delta)
+		acct.Data.RewardsBase = rewardsBase
+		changed[addr] = true
+	}
+	for _, au := range updates.AcfgUpdates {
+		creator, ok := ledger.creators[au.AssetId]
+		if !ok {
+			creator = au.Creator
+			ledger.creators[au.AssetId] = creator
+		}
+		ledger.account(creator).Data.AssetParams[types.AssetIndex(au.AssetId)] = au.Params
+		changed[creator] = true
+	}
+	for _, au := range updates.AssetUpdates {
+		acct := ledger.account(au.Addr)
+		holding, ok := acct.Data.Assets[types.AssetIndex(au.AssetId)]
+		if !ok {
+			holding.Frozen = au.DefaultFrozen
+		}
+		holding.Amount = uint64(int64(holding.Amount) + au.Delta)
+		acct.Data.Assets[types.AssetIndex(au.AssetId)] = holding
+		changed[au.Addr] = true
+	}
+	for _, fu := range updates.FreezeUpdates {
+		acct := ledger.account(fu.Addr)
+		holding := acct.Data.Assets[types.AssetIndex(fu.AssetId)]
+		holding.Frozen = fu.Frozen
+		acct.Data.Assets[types.AssetIndex(fu.AssetId)] = holding
+		changed[fu.Addr] = true
+	}
+	for _, ac := range updates.AssetCloses {
+		assetid := types.AssetIndex(ac.AssetId)
+		sender := ledger.account(ac.Sender)
+		if from, ok := sender.Data.Assets[assetid]; ok {
+			to := ledger.account(ac.CloseTo)
+			holding, ok := to.Data.Assets[assetid]
+			if !ok {
+				holding.Frozen = false
+			}
+			holding.Amount += from.Amount
+			to.Data.Assets[assetid] = holding
+			changed[ac.CloseTo] = true
+		}
+		delete(sender.Data.Assets, assetid)
+		changed[ac.Sender] = true
+	}
+	for _, assetid := range updates.AssetDestroys {
+		for addr, acct := range ledger.Accounts {
+			if _, ok := acct.Data.Assets[types.AssetIndex(assetid)]; ok {
+				delete(acct.Data.Assets, types.AssetIndex(assetid))
+				changed[addr] = true
+			}
+		}
+	}
+	for _, ku := range updates.KeyregUpdates {
+		acct, ok := ledger.Accounts[ku.Addr]
+		if !ok {
+			continue
+		}
+		acct.Data.Status = ku.Status
+		acct.Data.VoteID = ku.VoteID
+		acct.Data.SelectionID = ku.SelectionID
+		acct.Data.VoteFirstValid = ku.VoteFirstValid
+		acct.Data.VoteLastValid = ku.VoteLastValid
+		acct.Data.VoteKeyDilution = ku.VoteKeyDilution
+		changed[ku.Addr] = true
+	}
+	for addr := range updates.AlgoUpdates {
+		ledger.Accounts[addr].Deleted = false
+	}
+	for _, addr := range updates.AlgoCloses {
+		acct, ok := ledger.Accounts[addr]
+		if !ok {
+			continue
+		}
+		acct.Deleted = acct.Data.MicroAlgos == 0
+		clearParticipation(&acct.Data)
+		changed[addr] = true
+	}
+	if ledger.OnChange != nil {
+		for addr := range changed {
+			ledger.OnChange(round, addr, ledger.Accounts[addr])
+		}
+	}
+}
+
+// ledgerDb is an IndexerDb that commits accounting into a Ledger instead of the db it reads blocks from
+type ledgerDb struct {
+	idb.IndexerDb
+	ledger *Ledger
+}
+
+func (db *ledgerDb) CommitRoundAccounting(updates idb.RoundUpdates, round, rewardsBase uint64) error {
+	db.ledger.Apply(updates, round, rewardsBase)
+	return nil
+}
+
+func (db *ledgerDb) GetDefaultFrozen() (map[uint64]bool, error) {
+	return db.ledger.DefaultFrozen(), nil
+}
+
+// GetMetastate has no state, accounting into a Ledger starts from its genesis
+func (db *ledgerDb) GetMetastate(key string) (string, error) {
+	return "", nil
+}
+
+// NewInMemory is accounting that reads block headers from db and applies rounds to ledger
+func NewInMemory(db idb.IndexerDb, ledger *Ledger) *AccountingState {
+	return New(&ledgerDb{IndexerDb: db, ledger: ledger})
+}
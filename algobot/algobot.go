@@ -17,8 +17,14 @@
 package algobot
 
 import (
-	//"context"
-	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/algorand/go-algorand-sdk/client/algod"
 	"github.com/algorand/go-algorand-sdk/client/kmd"
@@ -27,6 +33,10 @@ import (
 type Algobot interface {
 	Algod() algod.Client
 	Kmd() kmd.Client
+
+	// AlgodNetToken returns the current algod address and api token.
+	// For a data dir these are re-read so that a restarted algod on a new port is found.
+	AlgodNetToken() (net, token string, err error)
 }
 
 type algobotImpl struct {
@@ -39,34 +49,174 @@ type algobotImpl struct {
 	kmdUrl   string
 	kmdToken string
 	kclient  kmd.Client
+
+	l sync.Mutex
 }
 
 func (bot *algobotImpl) Algod() algod.Client {
-	// TODO: lazy init of aclient
+	bot.l.Lock()
+	defer bot.l.Unlock()
+	err := bot.refreshAlgod()
+	if err != nil {
+		log.Printf("algod in %s: %v", bot.algorandData, err)
+	}
 	return bot.aclient
 }
 
+func (bot *algobotImpl) AlgodNetToken() (net, token string, err error) {
+	bot.l.Lock()
+	defer bot.l.Unlock()
+	err = bot.refreshAlgod()
+	return bot.algodUrl, bot.algodToken, err
+}
+
+// refreshAlgod (re)builds aclient when algod.net or algod.token have changed, which happens when algod restarts.
+func (bot *algobotImpl) refreshAlgod() (err error) {
+	if bot.algorandData == "" {
+		// fixed address, see ForNetToken()
+		return nil
+	}
+	url, token, err := readNetToken(bot.algorandData, "algod")
+	if err != nil {
+		return
+	}
+	if url == bot.algodUrl && token == bot.algodToken {
+		return nil
+	}
+	client, err := algod.MakeClient(url, token)
+	if err != nil {
+		return
+	}
+	bot.algodUrl = url
+	bot.algodToken = token
+	bot.aclient = client
+	return nil
+}
+
 func (bot *algobotImpl) Kmd() kmd.Client {
 	// TODO: ensure kmd is running
-	// TODO: lazy init of kclient
+	bot.l.Lock()
+	defer bot.l.Unlock()
+	err := bot.refreshKmd()
+	if err != nil {
+		log.Printf("kmd for %s: %v", bot.algorandData, err)
+	}
 	return bot.kclient
 }
 
+// refreshKmd (re)builds kclient when kmd.net or kmd.token have changed.
+func (bot *algobotImpl) refreshKmd() (err error) {
+	if bot.kmdDir == "" {
+		if bot.algorandData == "" {
+			return nil
+		}
+		bot.kmdDir, err = kmdDirForDataDir(bot.algorandData)
+		if err != nil {
+			return
+		}
+	}
+	url, token, err := readNetToken(bot.kmdDir, "kmd")
+	if err != nil {
+		return
+	}
+	if url == bot.kmdUrl && token == bot.kmdToken {
+		return nil
+	}
+	client, err := kmd.MakeClient(url, token)
+	if err != nil {
+		return
+	}
+	bot.kmdUrl = url
+	bot.kmdToken = token
+	bot.kclient = client
+	return nil
+}
+
+// ForDataDir returns an Algobot for the algod running in an ALGORAND_DATA dir.
+// Clients are built lazily, algod does not need to be running yet.
 func ForDataDir(path string) (bot Algobot, err error) {
-	return nil, errors.New("Not Implemented")
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", path)
+	}
+	return &algobotImpl{algorandData: path}, nil
+}
+
+// ForNetToken returns an Algobot for an algod at a fixed address. It has no kmd.
+func ForNetToken(net, token string) (bot Algobot, err error) {
+	if !strings.Contains(net, "://") {
+		net = "http://" + net
+	}
+	client, err := algod.MakeClient(net, token)
+	if err != nil {
+		return
+	}
+	return &algobotImpl{algodUrl: net, algodToken: token, aclient: client}, nil
+}
+
+// readNetToken reads {name}.net and {name}.token as written by algod and kmd into their data dirs.
+func readNetToken(dir, name string) (url, token string, err error) {
+	netpath := filepath.Join(dir, name+".net")
+	netbytes, err := ioutil.ReadFile(netpath)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", netpath, err)
+	}
+	tokenpath := filepath.Join(dir, name+".token")
+	tokenbytes, err := ioutil.ReadFile(tokenpath)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", tokenpath, err)
+	}
+	url = strings.TrimSpace(string(netbytes))
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	token = strings.TrimSpace(string(tokenbytes))
+	return
 }
 
 func algodClientForDataDir(path string) (client algod.Client, err error) {
-	// TODO: WRITEME
 	// TODO: move this to go-algorand-sdk
-	return algod.Client{}, nil
+	url, token, err := readNetToken(path, "algod")
+	if err != nil {
+		return
+	}
+	return algod.MakeClient(url, token)
+}
+
+// kmdDirForDataDir finds the newest kmd-v{N} dir, in the algod data dir if there is one there, otherwise ${HOME}/.algorand/kmd-v{N}
+func kmdDirForDataDir(path string) (kmdDir string, err error) {
+	searchDirs := []string{path}
+	home, herr := os.UserHomeDir()
+	if herr == nil {
+		searchDirs = append(searchDirs, filepath.Join(home, ".algorand"))
+	}
+	for _, dir := range searchDirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "kmd-v*"))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			sort.Strings(matches)
+			return matches[len(matches)-1], nil
+		}
+	}
+	return "", fmt.Errorf("no kmd-v* dir found for %s", path)
 }
 
 func kmdClientForDataDir(path string) (client kmd.Client, err error) {
-	// TODO: WRITEME
-	// TODO: use kmd in algod data dir if appropriate, otherwise ${HOME}/.algorand/kmd-v{N}
 	// TODO: move this to go-algorand-sdk
-	return kmd.Client{}, nil
+	kmdDir, err := kmdDirForDataDir(path)
+	if err != nil {
+		return
+	}
+	url, token, err := readNetToken(kmdDir, "kmd")
+	if err != nil {
+		return
+	}
+	return kmd.MakeClient(url, token)
 }
 
 /* TODO for general algobot
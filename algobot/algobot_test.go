@@ -0,0 +1,160 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package algobot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if content == "" {
+			os.Remove(path)
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDataDirNetToken(t *testing.T) {
+	dir := t.TempDir()
+	bot, err := ForDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each step changes files in the same data dir, "" removes one
+	steps := []struct {
+		name  string
+		files map[string]string
+		url   string
+		token string
+		err   bool
+	}{
+		{"not started", nil, "", "", true},
+		{"net but no token yet", map[string]string{"algod.net": "127.0.0.1:8080\n"}, "", "", true},
+		{"started", map[string]string{"algod.token": "aaaa\n"}, "http://127.0.0.1:8080", "aaaa", false},
+		{"restarted on a new port", map[string]string{"algod.net": "127.0.0.1:9090"}, "http://127.0.0.1:9090", "aaaa", false},
+		{"new token", map[string]string{"algod.token": "bbbb"}, "http://127.0.0.1:9090", "bbbb", false},
+		{"net with scheme", map[string]string{"algod.net": "https://[::1]:8443"}, "https://[::1]:8443", "bbbb", false},
+		{"stopped", map[string]string{"algod.net": ""}, "", "", true},
+	}
+	for _, step := range steps {
+		writeFiles(t, dir, step.files)
+		url, token, err := bot.AlgodNetToken()
+		if step.err {
+			if err == nil {
+				t.Errorf("%s: no error", step.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", step.name, err)
+			continue
+		}
+		if url != step.url || token != step.token {
+			t.Errorf("%s: got %s %s, want %s %s", step.name, url, token, step.url, step.token)
+		}
+	}
+}
+
+func TestForDataDirNotADir(t *testing.T) {
+	dir := t.TempDir()
+	_, err := ForDataDir(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("missing dir accepted")
+	}
+	path := filepath.Join(dir, "file")
+	writeFiles(t, dir, map[string]string{"file": "x"})
+	_, err = ForDataDir(path)
+	if err == nil {
+		t.Error("file accepted")
+	}
+}
+
+func TestKmdDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	tests := []struct {
+		name  string
+		files map[string]string
+		// kmd dir relative to the data dir, or to home if it starts with ~/
+		want string
+		err  bool
+	}{
+		{"none", nil, "", true},
+		{"in data dir", map[string]string{"kmd-v0.5/kmd.net": "127.0.0.1:7833"}, "kmd-v0.5", false},
+		{"newest in data dir", map[string]string{"kmd-v0.5/kmd.net": "a", "kmd-v0.6/kmd.net": "b"}, "kmd-v0.6", false},
+		{"in home", map[string]string{"~/.algorand/kmd-v0.5/kmd.net": "a"}, "~/.algorand/kmd-v0.5", false},
+		{"data dir first", map[string]string{"kmd-v0.5/kmd.net": "a", "~/.algorand/kmd-v0.6/kmd.net": "b"}, "kmd-v0.5", false},
+	}
+	for _, tc := range tests {
+		dataDir := t.TempDir()
+		os.RemoveAll(filepath.Join(home, ".algorand"))
+		for name, content := range tc.files {
+			dir := dataDir
+			if name[:2] == "~/" {
+				dir, name = home, name[2:]
+			}
+			writeFiles(t, dir, map[string]string{name: content})
+		}
+		kmdDir, err := kmdDirForDataDir(dataDir)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: found %s", tc.name, kmdDir)
+			}
+			continue
+		}
+		want := filepath.Join(dataDir, tc.want)
+		if tc.want[:2] == "~/" {
+			want = filepath.Join(home, tc.want[2:])
+		}
+		if err != nil || kmdDir != want {
+			t.Errorf("%s: got %s %v, want %s", tc.name, kmdDir, err, want)
+		}
+	}
+}
+
+func TestKmdNetTokenRefresh(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"kmd-v0.5/kmd.net": "127.0.0.1:7833", "kmd-v0.5/kmd.token": "kkkk"})
+	bot, err := ForDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := bot.(*algobotImpl)
+	err = impl.refreshKmd()
+	if err != nil || impl.kmdUrl != "http://127.0.0.1:7833" || impl.kmdToken != "kkkk" {
+		t.Fatalf("got %s %s %v", impl.kmdUrl, impl.kmdToken, err)
+	}
+	writeFiles(t, dir, map[string]string{"kmd-v0.5/kmd.net": "127.0.0.1:7900"})
+	err = impl.refreshKmd()
+	if err != nil || impl.kmdUrl != "http://127.0.0.1:7900" {
+		t.Fatalf("after restart got %s %v", impl.kmdUrl, err)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package algobot
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/encoding/json"
+)
+
+// ErrorPolicy says what a Runner does when a RawBlockHandler returns an error.
+type ErrorPolicy int
+
+const (
+	// RetryOnError retries the block with that handler until it succeeds. Later handlers wait.
+	RetryOnError ErrorPolicy = iota
+
+	// SkipOnError logs the error and moves that handler on to the next block.
+	SkipOnError
+
+	// HaltOnError stops the Runner.
+	HaltOnError
+)
+
+// NextRounder may be implemented by a RawBlockHandler that tracks its own progress (e.g. in a database).
+// Runner then starts it at NextRound() instead of at the round after the last one Runner recorded for it.
+type NextRounder interface {
+	NextRound() (round uint64, err error)
+}
+
+// MetastateStore is where a Runner persists the last round processed by each handler.
+// idb.IndexerDb implements this.
+type MetastateStore interface {
+	GetMetastate(key string) (jsonStrValue string, err error)
+	SetMetastate(key, jsonStrValue string) (err error)
+}
+
+type handlerState struct {
+	Round uint64 `codec:"round"`
+}
+
+type runnerHandler struct {
+	name    string
+	handler RawBlockHandler
+	policy  ErrorPolicy
+
+	// next round this handler needs
+	next uint64
+}
+
+// Runner fetches each new block from algod and hands it to registered handlers in the order they were added.
+type Runner struct {
+	bot      Algobot
+	state    MetastateStore
+	handlers []*runnerHandler
+	client   *http.Client
+
+	// maximum time between retries of a failed handler
+	MaxRetryWait time.Duration
+}
+
+func NewRunner(bot Algobot, state MetastateStore) *Runner {
+	return &Runner{
+		bot:          bot,
+		state:        state,
+		client:       &http.Client{Timeout: 2 * time.Minute},
+		MaxRetryWait: 30 * time.Second,
+	}
+}
+
+// AddHandler registers a handler. name is used as the key for persisting its progress and must be unique.
+func (runner *Runner) AddHandler(name string, handler RawBlockHandler, policy ErrorPolicy) {
+	runner.handlers = append(runner.handlers, &runnerHandler{name: name, handler: handler, policy: policy})
+}
+
+func handlerStateKey(name string) string {
+	return "algobot:" + name
+}
+
+func (runner *Runner) startRound(rh *runnerHandler) (round uint64, err error) {
+	if nr, ok := rh.handler.(NextRounder); ok {
+		return nr.NextRound()
+	}
+	js, err := runner.state.GetMetastate(handlerStateKey(rh.name))
+	if err != nil || js == "" {
+		return 0, err
+	}
+	var hs handlerState
+	err = json.Decode([]byte(js), &hs)
+	if err != nil {
+		return 0, fmt.Errorf("%s: bad handler state, %v", rh.name, err)
+	}
+	return hs.Round + 1, nil
+}
+
+func (runner *Runner) saveRound(rh *runnerHandler, round uint64) error {
+	hs := handlerState{Round: round}
+	return runner.state.SetMetastate(handlerStateKey(rh.name), string(json.Encode(hs)))
+}
+
+// Run processes blocks until ctx is done or a HaltOnError handler fails.
+func (runner *Runner) Run(ctx context.Context) (err error) {
+	if len(runner.handlers) == 0 {
+		return fmt.Errorf("no handlers")
+	}
+	for _, rh := range runner.handlers {
+		rh.next, err = runner.startRound(rh)
+		if err != nil {
+			return fmt.Errorf("%s: could not get start round, %v", rh.name, err)
+		}
+	}
+	for {
+		round := runner.handlers[0].next
+		for _, rh := range runner.handlers[1:] {
+			if rh.next < round {
+				round = rh.next
+			}
+		}
+		blockbytes, err := runner.fetchBlock(ctx, round)
+		if err != nil {
+			return err
+		}
+		for _, rh := range runner.handlers {
+			if rh.next > round {
+				continue
+			}
+			err = runner.handle(ctx, rh, round, blockbytes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (runner *Runner) handle(ctx context.Context, rh *runnerHandler, round uint64, blockbytes []byte) (err error) {
+	wait := time.Second
+	if wait > runner.MaxRetryWait {
+		wait = runner.MaxRetryWait
+	}
+	for {
+		err = rh.handler.HandleRawBlock(runner.bot, blockbytes)
+		if err == nil {
+			break
+		}
+		if rh.policy == HaltOnError {
+			return fmt.Errorf("%s: round %d, %v", rh.name, round, err)
+		}
+		if rh.policy == SkipOnError {
+			log.Printf("%s: round %d skipped, %v", rh.name, round, err)
+			break
+		}
+		log.Printf("%s: round %d will retry, %v", rh.name, round, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > runner.MaxRetryWait {
+			wait = runner.MaxRetryWait
+		}
+	}
+	rh.next = round + 1
+	err = runner.saveRound(rh, round)
+	if err != nil {
+		return fmt.Errorf("%s: could not save round %d, %v", rh.name, round, err)
+	}
+	return nil
+}
+
+// fetchBlock gets a block from algod, waiting for it if algod doesn't have it yet.
+func (runner *Runner) fetchBlock(ctx context.Context, round uint64) (blockbytes []byte, err error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		addr, token, err := runner.bot.AlgodNetToken()
+		if err != nil {
+			// algod not running or restarting
+			time.Sleep(time.Second)
+			continue
+		}
+		blockbytes, err = algodRawBlock(runner.client, addr, token, round)
+		if err == nil {
+			return blockbytes, nil
+		}
+		algodWaitForRound(runner.client, addr, token, round)
+	}
+}
+
+func algodGet(client *http.Client, addr, token, path string) (body []byte, err error) {
+	url := strings.TrimRight(addr, "/") + path
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+	if token != "" {
+		request.Header.Set("X-Algo-API-Token", token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// algodRawBlock gets msgpack encoded EncodedBlockCert for a round
+func algodRawBlock(client *http.Client, addr, token string, round uint64) (blockbytes []byte, err error) {
+	return algodGet(client, addr, token, fmt.Sprintf("/v1/block/%d?raw=1", round))
+}
+
+// algodWaitForRound blocks until algod has the round or some timeout passes.
+// A stand-in server that only serves blocks may not implement the wait endpoint, so fall back to sleeping.
+func algodWaitForRound(client *http.Client, addr, token string, round uint64) {
+	if round > 0 {
+		_, err := algodGet(client, addr, token, fmt.Sprintf("/v1/status/wait-for-block-after/%d", round-1))
+		if err == nil {
+			return
+		}
+	}
+	time.Sleep(time.Second)
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package algobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/encoding/json"
+)
+
+// algodStandIn serves "block N" as the raw block for rounds below numBlocks
+type algodStandIn struct {
+	numBlocks uint64
+}
+
+func (as *algodStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Algo-API-Token") != "tok" {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/status/wait-for-block-after/") {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	round, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/v1/block/"), 10, 64)
+	if err != nil || r.URL.Query().Get("raw") != "1" {
+		http.NotFound(w, r)
+		return
+	}
+	if round >= as.numBlocks {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, "block %d", round)
+}
+
+type memoryStore struct {
+	l  sync.Mutex
+	kv map[string]string
+}
+
+func (ms *memoryStore) GetMetastate(key string) (string, error) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	return ms.kv[key], nil
+}
+
+func (ms *memoryStore) SetMetastate(key, jsonStrValue string) error {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	if ms.kv == nil {
+		ms.kv = make(map[string]string)
+	}
+	ms.kv[key] = jsonStrValue
+	return nil
+}
+
+// roundRecorder records the rounds it's given, fails rounds in failures that many times, and cancels the run after stopAfter
+type roundRecorder struct {
+	failures  map[uint64]int
+	stopAfter uint64
+	cancel    func()
+	rounds    []uint64
+}
+
+func (rr *roundRecorder) HandleRawBlock(bot Algobot, blockbytes []byte) error {
+	round, err := strconv.ParseUint(strings.TrimPrefix(string(blockbytes), "block "), 10, 64)
+	if err != nil {
+		return err
+	}
+	rr.rounds = append(rr.rounds, round)
+	if rr.failures[round] > 0 {
+		rr.failures[round]--
+		return errors.New("not today")
+	}
+	if round >= rr.stopAfter {
+		rr.cancel()
+	}
+	return nil
+}
+
+type nextRoundRecorder struct {
+	roundRecorder
+	next uint64
+}
+
+func (nr *nextRoundRecorder) NextRound() (uint64, error) {
+	return nr.next, nil
+}
+
+func testRunner(t *testing.T, store *memoryStore) (*Runner, context.Context, func()) {
+	srv := httptest.NewServer(&algodStandIn{numBlocks: 100})
+	t.Cleanup(srv.Close)
+	bot, err := ForNetToken(strings.TrimPrefix(srv.URL, "http://"), "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := NewRunner(bot, store)
+	runner.MaxRetryWait = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return runner, ctx, cancel
+}
+
+func savedRound(t *testing.T, store *memoryStore, name string) uint64 {
+	js, _ := store.GetMetastate(handlerStateKey(name))
+	var hs handlerState
+	err := json.Decode([]byte(js), &hs)
+	if err != nil {
+		t.Fatalf("%s state %q, %v", name, js, err)
+	}
+	return hs.Round
+}
+
+func TestRunnerPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ErrorPolicy
+		// handled rounds
+		want  string
+		err   string
+		saved uint64
+	}{
+		{"retry", RetryOnError, "[0 1 2 2 2 3]", "context canceled", 3},
+		{"skip", SkipOnError, "[0 1 2 3]", "context canceled", 3},
+		{"halt", HaltOnError, "[0 1 2]", "h: round 2, not today", 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &memoryStore{}
+			runner, ctx, cancel := testRunner(t, store)
+			rr := &roundRecorder{failures: map[uint64]int{2: 2}, stopAfter: 3, cancel: cancel}
+			runner.AddHandler("h", rr, tc.policy)
+			err := runner.Run(ctx)
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("Run returned %v, want %s", err, tc.err)
+			}
+			if fmt.Sprint(rr.rounds) != tc.want {
+				t.Fatalf("handled %v, want %s", rr.rounds, tc.want)
+			}
+			if saved := savedRound(t, store, "h"); saved != tc.saved {
+				t.Fatalf("saved %d, want %d", saved, tc.saved)
+			}
+		})
+	}
+}
+
+func TestRunnerResumes(t *testing.T) {
+	store := &memoryStore{}
+	store.SetMetastate(handlerStateKey("h"), `{"round":5}`)
+	store.SetMetastate(handlerStateKey("n"), `{"round":50}`)
+	runner, ctx, cancel := testRunner(t, store)
+	rr := &roundRecorder{stopAfter: 8, cancel: cancel}
+	// tracks its own progress, which wins over what the runner saved
+	nr := &nextRoundRecorder{roundRecorder: roundRecorder{stopAfter: 1000, cancel: cancel}, next: 3}
+	runner.AddHandler("h", rr, HaltOnError)
+	runner.AddHandler("n", nr, HaltOnError)
+	err := runner.Run(ctx)
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rr.rounds) != "[6 7 8]" {
+		t.Fatalf("h handled %v, want [6 7 8]", rr.rounds)
+	}
+	if fmt.Sprint(nr.rounds) != "[3 4 5 6 7 8]" {
+		t.Fatalf("n handled %v, want [3 4 5 6 7 8]", nr.rounds)
+	}
+	if saved := savedRound(t, store, "n"); saved != 8 {
+		t.Fatalf("n saved %d, want 8", saved)
+	}
+}
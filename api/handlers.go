@@ -17,19 +17,21 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/algorand/go-algorand-sdk/client/algod/models"
-	"github.com/algorand/go-algorand-sdk/crypto"
 	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
 	atypes "github.com/algorand/go-algorand-sdk/types"
 	"github.com/gorilla/mux"
 
+	"github.com/algorand/indexer/idb"
 	"github.com/algorand/indexer/types"
 )
 
@@ -92,7 +94,7 @@ func formTime(r *http.Request, keySynonyms []string) (value time.Time, err error
 }
 
 type listAccountsReply struct {
-	Accounts []models.Account `json:"accounts,omitempty"`
+	Accounts []idb.Account `json:"accounts,omitempty"`
 }
 
 // ListAccounts is the http api handler that lists accounts and basic data
@@ -101,11 +103,18 @@ type listAccountsReply struct {
 // ?assets=1 // return AssetHolding for assets owned by this account
 // ?assetParams=1 // return AssetParams for assets created by this account
 // ?limit=N
-// return {"accounts":[]models.Account}
+// ?includeClosed=0 // leave out accounts that have been closed and not reopened, which are included by default
+// return {"accounts":[]idb.Account}
 func ListAccounts(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	var gtAddr types.Address
-	accounts, err := IndexerDb.GetAccounts(r.Context(), gtAddr, 10000)
+	includeClosed, err := formUint64(r, []string{"includeClosed"}, 1)
+	if err != nil {
+		log.Println("bad includeClosed, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	accounts, err := IndexerDb.GetAccounts(r.Context(), gtAddr, 10000, includeClosed != 0)
 	if err != nil {
 		log.Println("ListAccounts ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -119,6 +128,78 @@ func ListAccounts(w http.ResponseWriter, r *http.Request) {
 	err = enc.Encode(out)
 }
 
+type accountTotalsReply struct {
+	idb.AccountTotals
+	TotalMoney uint64 `json:"totalMoney"`
+}
+
+// AccountTotals is the http api handler for total supply and stake by participation status
+// /v1/totals
+// ?round=N // as of round N, default latest
+// return {"round":N, "online":{"money":N, "rewardUnits":N}, "offline":{...}, "notParticipating":{...}, "rewardsLevel":N, "totalMoney":N}
+func AccountTotals(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	round, err := formUint64(r, []string{"round", "r"}, math.MaxInt64)
+	if err != nil {
+		log.Println("bad round, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	totals, err := IndexerDb.GetAccountTotals(round)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("AccountTotals ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = writeJson(accountTotalsReply{AccountTotals: totals, TotalMoney: totals.Money()}, w)
+	if err != nil {
+		log.Println("totals json out, ", err)
+	}
+}
+
+// GetAccount is the http api handler for one account's balances
+// /v1/account/{address}
+// ?round=N // as of the end of round N, default latest
+// return idb.Account with Assets
+func GetAccount(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	queryAddr := mux.Vars(r)["address"]
+	addr, err := atypes.DecodeAddress(queryAddr)
+	if err != nil {
+		log.Println("bad addr, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	round, err := formUint64(r, []string{"round", "r"}, math.MaxInt64)
+	if err != nil {
+		log.Println("bad round, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	account, err := IndexerDb.GetAccountAtRound(r.Context(), addr, round)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("GetAccount ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = writeJson(&account, w)
+	if err != nil {
+		log.Println("account json out, ", err)
+	}
+}
+
 // TransactionsForAddress returns transactions for some account.
 // most-recent first, into the past.
 // ?limit=N  default 100? 10? 50? 20 kB?
@@ -197,7 +278,7 @@ func TransactionsForAddress(w http.ResponseWriter, r *http.Request) {
 }
 
 func addrJson(addr atypes.Address) string {
-	if addr.IsZero() {
+	if addr == (atypes.Address{}) {
 		return ""
 	}
 	return addr.String()
@@ -205,7 +286,7 @@ func addrJson(addr atypes.Address) string {
 
 func setApiTxn(out *models.Transaction, stxn types.SignedTxnInBlock) {
 	out.Type = stxn.Txn.Type
-	out.TxID = crypto.TransactionIDString(stxn.Txn)
+	out.TxID = types.TransactionIDString(stxn.Txn)
 	out.From = addrJson(stxn.Txn.Sender)
 	out.Fee = uint64(stxn.Txn.Fee)
 	out.FirstRound = uint64(stxn.Txn.FirstValid)
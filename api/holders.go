@@ -0,0 +1,244 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	atypes "github.com/algorand/go-algorand-sdk/types"
+	"github.com/gorilla/mux"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+type assetHolderJson struct {
+	Address string `json:"address"`
+	Amount  uint64 `json:"amount"`
+	Frozen  bool   `json:"frozen"`
+}
+
+func holderJson(holder idb.AssetHolder) assetHolderJson {
+	return assetHolderJson{
+		Address: atypes.Address(holder.Address).String(),
+		Amount:  holder.Amount,
+		Frozen:  holder.Frozen,
+	}
+}
+
+// AssetHolderWriter exports asset holders as "csv" or "ndjson"
+type AssetHolderWriter struct {
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+// NewAssetHolderWriter starts an export, csv starts with a header line
+func NewAssetHolderWriter(out io.Writer, format string) (hw *AssetHolderWriter, err error) {
+	switch format {
+	case "csv":
+		hw = &AssetHolderWriter{csv: csv.NewWriter(out)}
+		err = hw.csv.Write([]string{"address", "amount", "frozen"})
+	case "ndjson":
+		hw = &AssetHolderWriter{enc: json.NewEncoder(out)}
+	default:
+		err = fmt.Errorf("unknown asset holder format %#v, want csv or ndjson", format)
+	}
+	return
+}
+
+func (hw *AssetHolderWriter) Write(holders []idb.AssetHolder) (err error) {
+	for _, holder := range holders {
+		hj := holderJson(holder)
+		if hw.csv != nil {
+			err = hw.csv.Write([]string{hj.Address, strconv.FormatUint(hj.Amount, 10), strconv.FormatBool(hj.Frozen)})
+		} else {
+			err = hw.enc.Encode(hj)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Flush must be called at the end of an export
+func (hw *AssetHolderWriter) Flush() error {
+	if hw.csv != nil {
+		hw.csv.Flush()
+		return hw.csv.Error()
+	}
+	return nil
+}
+
+type assetHoldersReply struct {
+	Holders []assetHolderJson `json:"holders"`
+	// Next is the gt= for the next page, if there may be one
+	Next string `json:"next,omitempty"`
+}
+
+// exportPageSize is how many holders at a time a csv or ndjson export reads
+var exportPageSize = idb.MaxAssetHoldersLimit
+
+// AssetHolders is the http api handler for the holders of an asset
+// /v1/asset/{assetid}/holders
+// ?round=N // as of the end of round N, default latest
+// ?gt={addr} // return holders greater than some addr, for paging
+// ?limit=N // at most and by default 10000
+// ?format=csv|ndjson // default json. Every holder unless limit, otherwise a page with the next gt in the X-Next header
+// return {"holders":[{"address":"", "amount":N, "frozen":bool}], "next":"addr"}
+func AssetHolders(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	assetid, err := strconv.ParseUint(mux.Vars(r)["assetid"], 10, 64)
+	if err != nil {
+		log.Println("bad assetid, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	round, err := formUint64(r, []string{"round", "r"}, math.MaxInt64)
+	if err != nil {
+		log.Println("bad round, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit, err := formUint64(r, []string{"limit", "l"}, 0)
+	if err != nil {
+		log.Println("bad limit, ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var gtAddr types.Address
+	if gt := r.Form.Get("gt"); gt != "" {
+		gtAddr, err = atypes.DecodeAddress(gt)
+		if err != nil {
+			log.Println("bad gt, ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	format := r.Form.Get("format")
+	var hw *AssetHolderWriter
+	if format != "" && format != "json" {
+		hw, err = NewAssetHolderWriter(w, format)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > idb.MaxAssetHoldersLimit {
+		limit = idb.MaxAssetHoldersLimit
+	}
+	pageSize := idb.AssetHoldersLimit(int(limit))
+	if hw != nil && limit == 0 {
+		exportAssetHolders(w, r, hw, format, assetid, round, gtAddr)
+		return
+	}
+	holders, err := IndexerDb.GetAssetHolders(r.Context(), assetid, round, gtAddr, pageSize)
+	if err != nil {
+		log.Println("AssetHolders ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	next := ""
+	if len(holders) == pageSize {
+		next = atypes.Address(holders[len(holders)-1].Address).String()
+	}
+	if hw != nil {
+		if next != "" {
+			w.Header().Set("X-Next", next)
+		}
+		setExportContentType(w, format)
+		w.WriteHeader(http.StatusOK)
+		err = hw.Write(holders)
+		if err == nil {
+			err = hw.Flush()
+		}
+		if err != nil {
+			log.Println("holders out, ", err)
+		}
+		return
+	}
+	out := assetHoldersReply{Holders: make([]assetHolderJson, len(holders))}
+	for i, holder := range holders {
+		out.Holders[i] = holderJson(holder)
+	}
+	out.Next = next
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = writeJson(&out, w)
+	if err != nil {
+		log.Println("holders json out, ", err)
+	}
+}
+
+func setExportContentType(w http.ResponseWriter, format string) {
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+}
+
+// exportAssetHolders streams every holder after gtAddr, a page at a time like indexer asset-holders.
+// Once the first page is out an error can only be logged, the output just stops.
+func exportAssetHolders(w http.ResponseWriter, r *http.Request, hw *AssetHolderWriter, format string, assetid, round uint64, gtAddr types.Address) {
+	if round == math.MaxInt64 {
+		// every page as of the same round even if accounting moves on meanwhile
+		stateJsonStr, err := IndexerDb.GetMetastate("state")
+		if err == nil && stateJsonStr != "" {
+			state, err := idb.ParseImportState(stateJsonStr)
+			if err == nil && state.AccountRound >= 0 {
+				round = uint64(state.AccountRound)
+			}
+		}
+	}
+	holders, err := IndexerDb.GetAssetHolders(r.Context(), assetid, round, gtAddr, exportPageSize)
+	if err != nil {
+		log.Println("AssetHolders ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setExportContentType(w, format)
+	w.WriteHeader(http.StatusOK)
+	for len(holders) > 0 {
+		err = hw.Write(holders)
+		if err != nil {
+			log.Println("holders out, ", err)
+			return
+		}
+		if len(holders) < exportPageSize {
+			break
+		}
+		gtAddr = holders[len(holders)-1].Address
+		holders, err = IndexerDb.GetAssetHolders(r.Context(), assetid, round, gtAddr, exportPageSize)
+		if err != nil {
+			log.Println("AssetHolders ", err)
+			return
+		}
+	}
+	err = hw.Flush()
+	if err != nil {
+		log.Println("holders out, ", err)
+	}
+}
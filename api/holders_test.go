@@ -0,0 +1,149 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	atypes "github.com/algorand/go-algorand-sdk/types"
+	"github.com/gorilla/mux"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+// holdersDb has the holders of one asset, in address order
+type holdersDb struct {
+	idb.IndexerDb
+
+	holders []idb.AssetHolder
+	state   string
+	// rounds GetAssetHolders was asked for
+	rounds []uint64
+}
+
+func (db *holdersDb) GetAssetHolders(ctx context.Context, assetid, round uint64, greaterThan types.Address, limit int) (holders []idb.AssetHolder, err error) {
+	db.rounds = append(db.rounds, round)
+	limit = idb.AssetHoldersLimit(limit)
+	for _, h := range db.holders {
+		if bytes.Compare(h.Address[:], greaterThan[:]) > 0 && len(holders) < limit {
+			holders = append(holders, h)
+		}
+	}
+	return
+}
+
+func (db *holdersDb) GetMetastate(key string) (string, error) {
+	return db.state, nil
+}
+
+func testHolders(t *testing.T, n int) *holdersDb {
+	db := &holdersDb{state: `{"account_round":77}`}
+	for i := 0; i < n; i++ {
+		var h idb.AssetHolder
+		h.Address[0] = byte(i + 1)
+		h.Amount = uint64(i * 10)
+		db.holders = append(db.holders, h)
+	}
+	prevDb, prevPage := IndexerDb, exportPageSize
+	IndexerDb = db
+	exportPageSize = 4
+	t.Cleanup(func() { IndexerDb, exportPageSize = prevDb, prevPage })
+	return db
+}
+
+func getHolders(t *testing.T, query string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/asset/{assetid}/holders", AssetHolders)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/asset/5/holders"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", query, w.Code)
+	}
+	return w
+}
+
+func TestAssetHoldersExportsEveryPage(t *testing.T) {
+	db := testHolders(t, 10)
+	w := getHolders(t, "?format=csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 11 || lines[0] != "address,amount,frozen" {
+		t.Fatalf("%d lines starting %q", len(lines), lines[0])
+	}
+	want := atypes.Address(db.holders[9].Address).String() + ",90,false"
+	if lines[10] != want {
+		t.Fatalf("last line %q, want %q", lines[10], want)
+	}
+	// 4, 4 and 2, all as of the round accounting was at
+	if len(db.rounds) != 3 || db.rounds[0] != 77 || db.rounds[2] != 77 {
+		t.Fatalf("pages at rounds %v", db.rounds)
+	}
+	if w.Header().Get("X-Next") != "" {
+		t.Fatalf("X-Next on a full export")
+	}
+}
+
+func TestAssetHoldersExportPage(t *testing.T) {
+	db := testHolders(t, 10)
+	w := getHolders(t, "?format=ndjson&limit=3")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d lines", len(lines))
+	}
+	next := w.Header().Get("X-Next")
+	if next != atypes.Address(db.holders[2].Address).String() {
+		t.Fatalf("X-Next %q", next)
+	}
+	w = getHolders(t, "?format=ndjson&limit=3&gt="+next)
+	var first assetHolderJson
+	err := json.Unmarshal([]byte(strings.Split(w.Body.String(), "\n")[0]), &first)
+	if err != nil || first.Amount != 30 {
+		t.Fatalf("second page starts %v %v", first, err)
+	}
+}
+
+func TestAssetHoldersNext(t *testing.T) {
+	tests := []struct {
+		query string
+		count int
+		next  bool
+	}{
+		{"", 10, false},
+		{"?limit=10", 10, true},
+		{"?limit=4", 4, true},
+		{"?limit=20", 10, false},
+		{"?limit=100000", 10, false},
+	}
+	for _, tc := range tests {
+		testHolders(t, 10)
+		w := getHolders(t, tc.query)
+		var out assetHoldersReply
+		err := json.Unmarshal(w.Body.Bytes(), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Holders) != tc.count || (out.Next != "") != tc.next {
+			t.Errorf("%s: %d holders next %q", tc.query, len(out.Holders), out.Next)
+		}
+	}
+}
@@ -32,7 +32,10 @@ var IndexerDb idb.IndexerDb
 func Serve() {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/accounts", ListAccounts)
+	r.HandleFunc("/v1/account/{address}", GetAccount)
 	r.HandleFunc("/v1/account/{address}/transactions", TransactionsForAddress)
+	r.HandleFunc("/v1/totals", AccountTotals)
+	r.HandleFunc("/v1/asset/{assetid}/holders", AssetHolders)
 	s := &http.Server{
 		Addr:           ":8080",
 		Handler:        r,
@@ -0,0 +1,86 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/algorand/indexer/importer"
+)
+
+// msgpack fixmap/fixstr headers for the two fields of types.EncodedBlockCert
+var (
+	blockCertMapHeader = []byte{0x82}
+	blockKey           = append([]byte{0xa5}, "block"...)
+	certKey            = append([]byte{0xa4}, "cert"...)
+	// an empty agreement.Certificate, stored for the genesis block
+	emptyCert = []byte{0x80}
+)
+
+// encodeBlockCert reassembles the msgpack algod serves from /v1/block/N?raw=1 out of the separately encoded block and certificate.
+// The stored bytes are copied as-is so nothing is lost to a decode and re-encode.
+func encodeBlockCert(blkdata, certdata []byte) []byte {
+	if len(certdata) == 0 {
+		certdata = emptyCert
+	}
+	out := make([]byte, 0, len(blockCertMapHeader)+len(blockKey)+len(blkdata)+len(certKey)+len(certdata))
+	out = append(out, blockCertMapHeader...)
+	out = append(out, blockKey...)
+	out = append(out, blkdata...)
+	out = append(out, certKey...)
+	out = append(out, certdata...)
+	return out
+}
+
+// importBlockDb reads blocks from algod's ledger.block.sqlite in round order, starting at round.
+func importBlockDb(imp importer.Importer, path string, round uint64) (count int, err error) {
+	bdb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return
+	}
+	defer bdb.Close()
+	rows, err := bdb.Query(`SELECT rnd, blkdata, certdata FROM blocks WHERE rnd >= ? ORDER BY rnd`, round)
+	if err != nil {
+		return count, fmt.Errorf("%s: %v", path, err)
+	}
+	defer rows.Close()
+	lastlog := time.Now()
+	for rows.Next() {
+		var rnd uint64
+		var blkdata, certdata []byte
+		err = rows.Scan(&rnd, &blkdata, &certdata)
+		if err != nil {
+			return count, fmt.Errorf("%s: %v", path, err)
+		}
+		err = imp.ImportBlock(encodeBlockCert(blkdata, certdata))
+		if err != nil {
+			return count, fmt.Errorf("%s: round %d: %v", path, rnd, err)
+		}
+		count++
+		now := time.Now()
+		if now.Sub(lastlog) > (5 * time.Second) {
+			fmt.Printf("%s: queued through round %d\n", path, rnd)
+			lastlog = now
+		}
+	}
+	err = rows.Err()
+	return
+}
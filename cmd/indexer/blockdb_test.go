@@ -0,0 +1,147 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	atypes "github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/algorand/indexer/types"
+)
+
+func testBlockCert(round uint64) (blkdata, certdata []byte) {
+	var block types.Block
+	block.Round = types.Round(round)
+	block.GenesisID = "privnet-v1"
+	block.TimeStamp = 1592268590 + int64(round)
+	block.RewardsLevel = round
+	if round == 0 {
+		// algod stores no certificate for the genesis block
+		return msgpack.Encode(block), nil
+	}
+	var stxn types.SignedTxnInBlock
+	stxn.Txn.Type = atypes.PaymentTx
+	stxn.Txn.Sender[0] = 1
+	stxn.Txn.Receiver[0] = 2
+	stxn.Txn.Amount = atypes.MicroAlgos(round)
+	stxn.Txn.Note = []byte("note")
+	stxn.SenderRewards = 7
+	block.Payset = types.Payset{stxn}
+	var sender, proof [80]byte
+	sender[0] = 9
+	proof[0] = byte(round)
+	// a map because the vote types aren't exported
+	cert := map[string]interface{}{
+		"rnd":  round,
+		"step": 2,
+		"prop": map[string]interface{}{"oper": 1, "dig": sender[:32]},
+		"vote": []interface{}{
+			map[string]interface{}{"snd": sender[:32], "cred": map[string]interface{}{"pf": proof[:]}},
+		},
+	}
+	return msgpack.Encode(block), msgpack.Encode(cert)
+}
+
+func TestEncodeBlockCert(t *testing.T) {
+	for _, round := range []uint64{0, 1, 2} {
+		blkdata, certdata := testBlockCert(round)
+		out := encodeBlockCert(blkdata, certdata)
+
+		var bc types.EncodedBlockCert
+		err := msgpack.Decode(out, &bc)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		var block types.Block
+		err = msgpack.Decode(blkdata, &block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(bc.Block, block) {
+			t.Errorf("round %d: block %#v, want %#v", round, bc.Block, block)
+		}
+		var cert types.Certificate
+		if certdata != nil {
+			err = msgpack.Decode(certdata, &cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(cert.Round) != round || len(cert.Votes) != 1 {
+				t.Fatalf("round %d: test cert decoded as %#v", round, cert)
+			}
+		}
+		if !reflect.DeepEqual(bc.Certificate, cert) {
+			t.Errorf("round %d: cert %#v, want %#v", round, bc.Certificate, cert)
+		}
+		// the block is the same bytes as re-encoding it, and both parts are copied in unchanged
+		if !bytes.Equal(msgpack.Encode(bc.Block), blkdata) {
+			t.Errorf("round %d: block does not re-encode to the stored bytes", round)
+		}
+		if !bytes.Contains(out, blkdata) || (certdata != nil && !bytes.HasSuffix(out, certdata)) {
+			t.Errorf("round %d: stored bytes not copied as-is", round)
+		}
+	}
+}
+
+type recordingImporter struct {
+	blocks [][]byte
+}
+
+func (ri *recordingImporter) ImportBlock(blockbytes []byte) error {
+	ri.blocks = append(ri.blocks, blockbytes)
+	return nil
+}
+
+func TestImportBlockDb(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.block.sqlite")
+	bdb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// algod's blocks table, ledger/blockdb.go
+	_, err = bdb.Exec(`CREATE TABLE blocks (rnd integer primary key, proto text, hdrdata blob, blkdata blob, certdata blob)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inserted out of order, read back in round order
+	for _, round := range []uint64{3, 0, 1, 4, 2} {
+		blkdata, certdata := testBlockCert(round)
+		_, err = bdb.Exec(`INSERT INTO blocks (rnd, proto, hdrdata, blkdata, certdata) VALUES (?, 'test', x'80', ?, ?)`, round, blkdata, certdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bdb.Close()
+
+	var imp recordingImporter
+	count, err := importBlockDb(&imp, path, 2)
+	if err != nil || count != 3 {
+		t.Fatalf("imported %d, %v", count, err)
+	}
+	for i, blockbytes := range imp.blocks {
+		round := uint64(i + 2)
+		if !bytes.Equal(blockbytes, encodeBlockCert(testBlockCert(round))) {
+			t.Errorf("block %d is not round %d", i, round)
+		}
+	}
+}
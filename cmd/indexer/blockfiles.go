@@ -0,0 +1,265 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	stdbzip2 "compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/algorand/indexer/importer"
+)
+
+// blockSink is somewhere to put blocks in round order
+type blockSink interface {
+	AddBlock(round uint64, blockbytes []byte) error
+	Close() error
+}
+
+// blockFileSink writes each block to a file named by its round, as blockfetcher.py did
+type blockFileSink struct {
+	outdir string
+}
+
+func (bfs *blockFileSink) AddBlock(round uint64, blockbytes []byte) error {
+	return ioutil.WriteFile(filepath.Join(bfs.outdir, strconv.FormatUint(round, 10)), blockbytes, 0666)
+}
+
+func (bfs *blockFileSink) Close() error {
+	return nil
+}
+
+// importerSink puts blocks straight into an Importer
+type importerSink struct {
+	imp importer.Importer
+}
+
+func (is *importerSink) AddBlock(round uint64, blockbytes []byte) error {
+	return is.imp.ImportBlock(blockbytes)
+}
+
+func (is *importerSink) Close() error {
+	return nil
+}
+
+var tarSuffixes = map[string]string{
+	"":     ".tar",
+	"none": ".tar",
+	"bz2":  ".tar.bz2",
+	"gz":   ".tar.gz",
+	"zst":  ".tar.zst",
+}
+
+// isBlockTarPath is true for the archive names openBlockTar can read
+func isBlockTarPath(fname string) bool {
+	for _, suffix := range tarSuffixes {
+		if strings.HasSuffix(fname, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// openBlockTar opens a .tar, .tar.bz2, .tar.gz or .tar.zst file for importTar
+func openBlockTar(fname string) (in io.Reader, closer func(), err error) {
+	fin, err := os.Open(fname)
+	if err != nil {
+		return
+	}
+	switch {
+	case strings.HasSuffix(fname, ".tar"):
+		return fin, func() { fin.Close() }, nil
+	case strings.HasSuffix(fname, ".tar.bz2"):
+		return stdbzip2.NewReader(fin), func() { fin.Close() }, nil
+	case strings.HasSuffix(fname, ".tar.gz"):
+		gzin, err := gzip.NewReader(fin)
+		if err != nil {
+			fin.Close()
+			return nil, nil, err
+		}
+		return gzin, func() { gzin.Close(); fin.Close() }, nil
+	case strings.HasSuffix(fname, ".tar.zst"):
+		zin, err := zstd.NewReader(fin)
+		if err != nil {
+			fin.Close()
+			return nil, nil, err
+		}
+		return zin, func() { zin.Close(); fin.Close() }, nil
+	}
+	fin.Close()
+	return nil, nil, fmt.Errorf("%s: not a block tar file", fname)
+}
+
+// blockTarWriter writes blocks into {first}_{last}.tar[.bz2|.gz|.zst] archives as read by importTar.
+// An archive is written under a temporary name and renamed when complete.
+type blockTarWriter struct {
+	outdir        string
+	compression   string
+	blocksPerFile int
+
+	// LastPath is the most recently completed archive
+	LastPath string
+
+	tmpPath string
+	fout    *os.File
+	zout    io.WriteCloser // compression layer, nil for plain .tar
+	tout    *tar.Writer
+	first   uint64
+	last    uint64
+	count   int
+}
+
+func newBlockTarWriter(outdir, compression string, blocksPerFile int) (btw *blockTarWriter, err error) {
+	_, ok := tarSuffixes[compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %#v", compression)
+	}
+	return &blockTarWriter{outdir: outdir, compression: compression, blocksPerFile: blocksPerFile}, nil
+}
+
+func (btw *blockTarWriter) open(round uint64) (err error) {
+	btw.tmpPath = filepath.Join(btw.outdir, fmt.Sprintf(".%d_partial%s", round, tarSuffixes[btw.compression]))
+	btw.fout, err = os.Create(btw.tmpPath)
+	if err != nil {
+		return
+	}
+	switch btw.compression {
+	case "bz2":
+		btw.zout, err = bzip2.NewWriter(btw.fout, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+	case "gz":
+		btw.zout, err = gzip.NewWriterLevel(btw.fout, gzip.BestCompression)
+	case "zst":
+		btw.zout, err = zstd.NewWriter(btw.fout)
+	}
+	if err != nil {
+		btw.fout.Close()
+		return
+	}
+	if btw.zout != nil {
+		btw.tout = tar.NewWriter(btw.zout)
+	} else {
+		btw.tout = tar.NewWriter(btw.fout)
+	}
+	btw.first = round
+	btw.count = 0
+	return nil
+}
+
+func (btw *blockTarWriter) AddBlock(round uint64, blockbytes []byte) (err error) {
+	if btw.tout == nil {
+		err = btw.open(round)
+		if err != nil {
+			return
+		}
+	}
+	header := tar.Header{
+		Name:    strconv.FormatUint(round, 10),
+		Mode:    0666,
+		Size:    int64(len(blockbytes)),
+		ModTime: time.Now(),
+	}
+	err = btw.tout.WriteHeader(&header)
+	if err != nil {
+		return
+	}
+	_, err = btw.tout.Write(blockbytes)
+	if err != nil {
+		return
+	}
+	btw.last = round
+	btw.count++
+	if btw.count >= btw.blocksPerFile {
+		return btw.finish()
+	}
+	return nil
+}
+
+// finish closes the current archive and renames it to {first}_{last}.tar*
+func (btw *blockTarWriter) finish() (err error) {
+	err = btw.tout.Close()
+	if err != nil {
+		return
+	}
+	if btw.zout != nil {
+		err = btw.zout.Close()
+		if err != nil {
+			return
+		}
+	}
+	err = btw.fout.Close()
+	if err != nil {
+		return
+	}
+	path := filepath.Join(btw.outdir, fmt.Sprintf("%d_%d%s", btw.first, btw.last, tarSuffixes[btw.compression]))
+	err = os.Rename(btw.tmpPath, path)
+	if err != nil {
+		return
+	}
+	fmt.Println(path)
+	btw.LastPath = path
+	btw.tout = nil
+	btw.zout = nil
+	btw.fout = nil
+	return nil
+}
+
+// Close writes out any partial archive
+func (btw *blockTarWriter) Close() error {
+	if btw.tout == nil {
+		return nil
+	}
+	return btw.finish()
+}
+
+// lastBlockFileRound finds the highest round in a dir of block files and {first}_{last}.tar* archives.
+// returns -1 if there are none.
+func lastBlockFileRound(dir string) (last int64, err error) {
+	last = -1
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		round := pathNameEndInt(name)
+		if round == -1 {
+			var err error
+			round, err = strconv.ParseInt(name, 10, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if round > last {
+			last = round
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/idb"
+)
+
+var (
+	trackerDbPath       string
+	bootstrapRound      int64
+	bootstrapTxnCounter int64
+	bootstrapForce      bool
+)
+
+// readTrackerAccounts reads every account from algod's ledger.tracker.sqlite and the round they are current as of
+func readTrackerAccounts(path string) (round uint64, accounts []idb.AccountState, err error) {
+	tdb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return
+	}
+	defer tdb.Close()
+	err = tdb.QueryRow(`SELECT rnd FROM acctrounds WHERE id = 'acctbase'`).Scan(&round)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: reading account round, %v", path, err)
+	}
+	rows, err := tdb.Query(`SELECT address, data FROM accountbase`)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addrbytes, data []byte
+		err = rows.Scan(&addrbytes, &data)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var acct idb.AccountState
+		if len(addrbytes) != len(acct.Address) {
+			return 0, nil, fmt.Errorf("%s: bad address length %d", path, len(addrbytes))
+		}
+		copy(acct.Address[:], addrbytes)
+		err = msgpack.Decode(data, &acct.Data)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s: decoding account data, %v", path, err)
+		}
+		accounts = append(accounts, acct)
+	}
+	err = rows.Err()
+	return
+}
+
+var bootstrapAccountsCmd = &cobra.Command{
+	Use:   "bootstrap-accounts",
+	Short: "load account state from an algod tracker db",
+	Long:  "load account, asset holding and asset state from algod's ledger.tracker.sqlite so that accounting continues from that round instead of replaying from genesis.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		if trackerDbPath == "" {
+			fmt.Fprintf(os.Stderr, "need --tracker ledger.tracker.sqlite\n")
+			os.Exit(1)
+			return
+		}
+		db := globalIndexerDb()
+		stateJsonStr, err := db.GetMetastate("state")
+		maybeFail(err, "getting import state, %v\n", err)
+		if stateJsonStr != "" && !bootstrapForce {
+			fmt.Fprintf(os.Stderr, "db already has account state, use --force to replace it\n")
+			os.Exit(1)
+			return
+		}
+		round, accounts, err := readTrackerAccounts(trackerDbPath)
+		maybeFail(err, "%v\n", err)
+		if bootstrapRound >= 0 && uint64(bootstrapRound) != round {
+			fmt.Fprintf(os.Stderr, "%s: has accounts as of round %d, not %d\n", trackerDbPath, round, bootstrapRound)
+			os.Exit(1)
+			return
+		}
+		// the tracker db doesn't have it, new asset ids after round are derived from it
+		txnCounter := bootstrapTxnCounter
+		if txnCounter < 0 {
+			block, err := db.GetBlock(round)
+			if err == sql.ErrNoRows {
+				fmt.Fprintf(os.Stderr, "block %d isn't imported, need --txn-counter from its header\n", round)
+				os.Exit(1)
+				return
+			}
+			maybeFail(err, "getting block %d, %v\n", round, err)
+			txnCounter = int64(block.TxnCounter)
+		}
+		err = db.LoadAccounts(round, uint64(txnCounter), accounts)
+		maybeFail(err, "loading accounts, %v\n", err)
+		total := uint64(0)
+		for _, acct := range accounts {
+			total += uint64(acct.Data.MicroAlgos)
+		}
+		fmt.Printf("loaded %d accounts %d microalgos as of round %d\n", len(accounts), total, round)
+	},
+}
+
+func init() {
+	bootstrapAccountsCmd.Flags().StringVarP(&trackerDbPath, "tracker", "f", "", "algod ledger.tracker.sqlite, opened read-only")
+	bootstrapAccountsCmd.Flags().Int64VarP(&bootstrapRound, "round", "r", -1, "round the accounts must be current as of, default whatever round the tracker db is at")
+	bootstrapAccountsCmd.Flags().Int64VarP(&bootstrapTxnCounter, "txn-counter", "", -1, "TxnCounter of the block header for the tracker round, default from that block in the db")
+	bootstrapAccountsCmd.Flags().BoolVarP(&bootstrapForce, "force", "", false, "replace existing account state")
+}
@@ -17,20 +17,113 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
 	"github.com/spf13/cobra"
 
+	"github.com/algorand/indexer/accounting"
+	"github.com/algorand/indexer/algobot"
 	"github.com/algorand/indexer/api"
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/importer"
+	"github.com/algorand/indexer/types"
+)
+
+var (
+	algodDataDir string
+	algodAddr    string
+	algodToken   string
+	noAlgod      bool
 )
 
 var daemonCmd = &cobra.Command{
 	Use:   "daemon",
 	Short: "run indexer daemon",
-	Long:  "run indexer daemon. Serve api on HTTP. (TODO: follow blocks from algod)",
+	Long:  "run indexer daemon. Serve api on HTTP. Follow blocks from algod set by --algod or -d/$ALGORAND_DATA.",
 	//Args:
 	Run: func(cmd *cobra.Command, args []string) {
 		// TODO: -p/--port
-		// TODO: -d/$ALGORAND_DATA/--no-algod algod to follow
-		api.IndexerDb = globalIndexerDb()
+		if algodDataDir == "" {
+			algodDataDir = os.Getenv("ALGORAND_DATA")
+		}
+		db := globalIndexerDb()
+		if !noAlgod {
+			var bot algobot.Algobot
+			var err error
+			if algodAddr != "" {
+				bot, err = algobot.ForNetToken(algodAddr, algodToken)
+				maybeFail(err, "algod %s, %v\n", algodAddr, err)
+			} else if algodDataDir != "" {
+				bot, err = algobot.ForDataDir(algodDataDir)
+				maybeFail(err, "algod data dir %s, %v\n", algodDataDir, err)
+			}
+			if bot != nil {
+				go followAlgod(db, bot)
+			}
+		}
+		api.IndexerDb = db
 		api.Serve()
 	},
 }
+
+// accountBlock applies the txns of an already imported block to account state.
+func accountBlock(act *accounting.AccountingState, blockbytes []byte) (err error) {
+	var blockContainer types.EncodedBlockCert
+	err = msgpack.Decode(blockbytes, &blockContainer)
+	if err != nil {
+		return fmt.Errorf("error decoding blockbytes, %v", err)
+	}
+	round := uint64(blockContainer.Block.Round)
+	for intra, stxn := range blockContainer.Block.Payset {
+		err = act.AddTransaction(round, intra, msgpack.Encode(stxn))
+		if err != nil {
+			return
+		}
+	}
+	// commits the round, act remains usable for the next round
+	return act.Close()
+}
+
+type accountingHandler struct {
+	db  idb.IndexerDb
+	act *accounting.AccountingState
+}
+
+// HandleRawBlock is part of algobot.RawBlockHandler
+func (ah *accountingHandler) HandleRawBlock(bot algobot.Algobot, blockbytes []byte) error {
+	return accountBlock(ah.act, blockbytes)
+}
+
+// NextRound is part of algobot.NextRounder
+// Accounting is caught up to the last imported block before the runner starts.
+func (ah *accountingHandler) NextRound() (round uint64, err error) {
+	maxRound, err := ah.db.GetMaxRound()
+	if err != nil {
+		return
+	}
+	return uint64(maxRound + 1), nil
+}
+
+// followAlgod imports each new block from algod as it becomes available and updates account state.
+// It resumes after the last round in block_header.
+func followAlgod(db idb.IndexerDb, bot algobot.Algobot) {
+	// catch up accounting for anything imported but not yet accounted for
+	updateAccounting(db)
+
+	runner := algobot.NewRunner(bot, db)
+	runner.AddHandler("import", importer.NewDBBlockHandler(db), algobot.RetryOnError)
+	runner.AddHandler("accounting", &accountingHandler{db: db, act: accounting.New(db)}, algobot.HaltOnError)
+	err := runner.Run(context.Background())
+	maybeFail(err, "following algod, %v\n", err)
+}
+
+func init() {
+	daemonCmd.Flags().StringVarP(&algodDataDir, "algod-data", "d", "", "algod data dir to follow, default $ALGORAND_DATA")
+	daemonCmd.Flags().StringVarP(&algodAddr, "algod", "", "", "algod address to follow, e.g. http://127.0.0.1:8080")
+	daemonCmd.Flags().StringVarP(&algodToken, "algod-token", "", "", "algod api token")
+	daemonCmd.Flags().BoolVarP(&noAlgod, "no-algod", "", false, "only serve the api, don't follow an algod")
+	daemonCmd.Flags().StringVarP(&genesisJsonPath, "genesis", "g", "", "path to genesis.json, needed to start from an empty database")
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/importer"
+)
+
+var (
+	fetchHosts         []string
+	fetchGenesisId     string
+	fetchOutdir        string
+	fetchStartRound    int64
+	fetchEndRound      uint64
+	fetchBlocksPerFile int
+	fetchCompression   string
+	fetchRetries       int
+	fetchImport        bool
+)
+
+var errBlockNotFound = errors.New("block not found")
+
+// fetchRetryWait is the first backoff after a failed fetch, it doubles each retry
+var fetchRetryWait = time.Second
+
+// relayBlockUrl is the archival relay block url, the round is base36 encoded
+func relayBlockUrl(host, genesisId string, round uint64) string {
+	return fmt.Sprintf("http://%s/v1/%s/block/%s", host, genesisId, strconv.FormatUint(round, 36))
+}
+
+// lookupRelays finds relays for a network by the DNS SRV record algod uses to bootstrap, e.g.
+// _algobootstrap._tcp.mainnet.algorand.network. 150 IN SRV 1 1 4160 r-si.algorand-mainnet.network.
+func lookupRelays(genesisId string) (hosts []string, err error) {
+	network := genesisId
+	dashPos := strings.IndexRune(genesisId, '-')
+	if dashPos != -1 {
+		network = genesisId[:dashPos]
+	}
+	_, records, err := net.LookupSRV("algobootstrap", "tcp", network+".algorand.network")
+	if err != nil {
+		return
+	}
+	for _, srv := range records {
+		hosts = append(hosts, net.JoinHostPort(strings.TrimRight(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+	if len(hosts) == 0 {
+		err = fmt.Errorf("no relays found for %s", network)
+	}
+	return
+}
+
+func relayGet(client *http.Client, url string) (blockbytes []byte, status int, err error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	status = response.StatusCode
+	if status != http.StatusOK {
+		return nil, status, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	blockbytes, err = ioutil.ReadAll(response.Body)
+	return
+}
+
+// fetchBlock gets a block from the first relay that has it, backing off between retries.
+// errBlockNotFound if every relay says 404, which usually means the block doesn't exist yet.
+func fetchBlock(client *http.Client, hosts []string, genesisId string, round uint64, retries int) (blockbytes []byte, err error) {
+	wait := fetchRetryWait
+	notFound := 0
+	for attempt := 0; ; attempt++ {
+		host := hosts[attempt%len(hosts)]
+		var status int
+		blockbytes, status, err = relayGet(client, relayBlockUrl(host, genesisId, round))
+		if err == nil {
+			return blockbytes, nil
+		}
+		if status == http.StatusNotFound {
+			notFound++
+			if notFound >= len(hosts) {
+				return nil, errBlockNotFound
+			}
+			continue
+		}
+		if attempt >= retries {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "%v, retry in %s\n", err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// fetchRange fetches blocks from round through endRound, or until the relays don't have the next one if endRound is 0, into sink.
+// Rounds already in skipDir block files are skipped if it is set.
+// next is the round after the last one fetched or skipped.
+func fetchRange(client *http.Client, hosts []string, genesisId string, sink blockSink, round, endRound uint64, retries int, skipDir string) (fetched int, next uint64, err error) {
+	lastlog := time.Now()
+	for ; endRound == 0 || round <= endRound; round++ {
+		if skipDir != "" {
+			if _, err := os.Stat(filepath.Join(skipDir, strconv.FormatUint(round, 10))); err == nil {
+				// already have it
+				continue
+			}
+		}
+		blockbytes, err := fetchBlock(client, hosts, genesisId, round, retries)
+		if err == errBlockNotFound {
+			break
+		}
+		if err != nil {
+			return fetched, round, fmt.Errorf("round %d: %v", round, err)
+		}
+		err = sink.AddBlock(round, blockbytes)
+		if err != nil {
+			return fetched, round, fmt.Errorf("round %d: %v", round, err)
+		}
+		fetched++
+		now := time.Now()
+		if now.Sub(lastlog) > (5 * time.Second) {
+			fmt.Printf("fetched through round %d\n", round)
+			lastlog = now
+		}
+	}
+	return fetched, round, nil
+}
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "fetch blocks from an archival relay",
+	Long:  "fetch blocks from an archival relay into block files, tar files of blocks, or directly into the db. Resumes after the last block already fetched.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		hosts := fetchHosts
+		if len(hosts) == 0 {
+			var err error
+			hosts, err = lookupRelays(fetchGenesisId)
+			maybeFail(err, "finding relays, %v\n", err)
+		}
+		for i, host := range hosts {
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				hosts[i] = net.JoinHostPort(host, "4160")
+			}
+		}
+
+		var sink blockSink
+		var lastRound int64
+		var err error
+		if fetchImport {
+			db := globalIndexerDb()
+			imp := importer.NewDBImporter(db)
+			if verifyBlocks {
+				imp = importer.NewVerifyingDBImporter(db, newBlockVerifier(db))
+			}
+			sink = &importerSink{imp: imp}
+			lastRound, err = db.GetMaxRound()
+			maybeFail(err, "getting last imported round, %v\n", err)
+		} else {
+			err = os.MkdirAll(fetchOutdir, 0777)
+			maybeFail(err, "%s: %v\n", fetchOutdir, err)
+			if fetchBlocksPerFile > 0 {
+				sink, err = newBlockTarWriter(fetchOutdir, fetchCompression, fetchBlocksPerFile)
+				maybeFail(err, "%v\n", err)
+			} else {
+				sink = &blockFileSink{outdir: fetchOutdir}
+			}
+			lastRound, err = lastBlockFileRound(fetchOutdir)
+			maybeFail(err, "%s: %v\n", fetchOutdir, err)
+		}
+		round := uint64(lastRound + 1)
+		if fetchStartRound >= 0 {
+			round = uint64(fetchStartRound)
+		}
+
+		client := &http.Client{Timeout: 2 * time.Minute}
+		skipDir := ""
+		if !fetchImport && fetchBlocksPerFile == 0 {
+			skipDir = fetchOutdir
+		}
+		fetched, round, err := fetchRange(client, hosts, fetchGenesisId, sink, round, fetchEndRound, fetchRetries, skipDir)
+		maybeFail(err, "%v\n", err)
+		err = sink.Close()
+		maybeFail(err, "%v\n", err)
+		fmt.Printf("fetched %d blocks, next round %d\n", fetched, round)
+		if fetchImport {
+			updateAccounting(globalIndexerDb())
+		}
+	},
+}
+
+func init() {
+	fetchCmd.Flags().StringSliceVarP(&fetchHosts, "host", "", nil, "archival relay host[:port] to fetch from, default is to find relays by DNS SRV lookup")
+	fetchCmd.Flags().StringVarP(&fetchGenesisId, "genesis-id", "", "mainnet-v1.0", "genesis id of the network")
+	fetchCmd.Flags().StringVarP(&fetchOutdir, "outdir", "o", ".", "dir to write block files or tar files to")
+	fetchCmd.Flags().Int64VarP(&fetchStartRound, "start", "", -1, "first round to fetch, default is after the last one already fetched")
+	fetchCmd.Flags().Uint64VarP(&fetchEndRound, "end", "", 0, "last round to fetch, default is until the relay doesn't have the next block")
+	fetchCmd.Flags().IntVarP(&fetchBlocksPerFile, "blocks-per-file", "", 0, "number of blocks per tar file, 0 writes a file per block")
+	fetchCmd.Flags().StringVarP(&fetchCompression, "compress", "z", "bz2", "tar file compression: bz2, gz, zst or none")
+	fetchCmd.Flags().IntVarP(&fetchRetries, "retries", "", 5, "number of retries of a failed fetch")
+	fetchCmd.Flags().BoolVarP(&fetchImport, "import", "", false, "import blocks into the db instead of writing files")
+	fetchCmd.Flags().BoolVarP(&verifyBlocks, "verify", "", false, "with --import, check each block's genesis, branch and txn root before writing it")
+	fetchCmd.Flags().StringVarP(&genesisJsonPath, "genesis", "g", "", "path to genesis.json, needed by --import to start from an empty database")
+}
@@ -0,0 +1,300 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relayStandIn serves /v1/{genesis-id}/block/{base36} for rounds below numBlocks, 404 above
+type relayStandIn struct {
+	genesisId string
+	numBlocks uint64
+	// fail the first failures requests with a 500
+	failures int
+
+	mu       sync.Mutex
+	requests []uint64
+}
+
+func (rs *relayStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/v1/" + rs.genesisId + "/block/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	round, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, prefix), 36, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rs.mu.Lock()
+	rs.requests = append(rs.requests, round)
+	fail := rs.failures > 0
+	if fail {
+		rs.failures--
+	}
+	rs.mu.Unlock()
+	if fail {
+		http.Error(w, "try again", http.StatusInternalServerError)
+		return
+	}
+	if round >= rs.numBlocks {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, "block %d", round)
+}
+
+func (rs *relayStandIn) requestCount() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.requests)
+}
+
+func startRelay(t *testing.T, rs *relayStandIn) (host string) {
+	srv := httptest.NewServer(rs)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func fastRetries(t *testing.T) {
+	prev := fetchRetryWait
+	fetchRetryWait = time.Millisecond
+	t.Cleanup(func() { fetchRetryWait = prev })
+}
+
+// memorySink collects blocks by round
+type memorySink struct {
+	blocks map[uint64]string
+	closed bool
+}
+
+func (ms *memorySink) AddBlock(round uint64, blockbytes []byte) error {
+	if ms.blocks == nil {
+		ms.blocks = make(map[uint64]string)
+	}
+	ms.blocks[round] = string(blockbytes)
+	return nil
+}
+
+func (ms *memorySink) Close() error {
+	ms.closed = true
+	return nil
+}
+
+func TestRelayBlockUrl(t *testing.T) {
+	tests := []struct {
+		round uint64
+		url   string
+	}{
+		{0, "http://r1:4160/v1/testnet-v1.0/block/0"},
+		{35, "http://r1:4160/v1/testnet-v1.0/block/z"},
+		{36, "http://r1:4160/v1/testnet-v1.0/block/10"},
+		{1295, "http://r1:4160/v1/testnet-v1.0/block/zz"},
+		{6000000, "http://r1:4160/v1/testnet-v1.0/block/3klmo"},
+	}
+	for _, tc := range tests {
+		if url := relayBlockUrl("r1:4160", "testnet-v1.0", tc.round); url != tc.url {
+			t.Errorf("round %d: got %s, want %s", tc.round, url, tc.url)
+		}
+	}
+}
+
+func TestFetchStopsAtNotFound(t *testing.T) {
+	rs := &relayStandIn{genesisId: "test-v1", numBlocks: 5}
+	host := startRelay(t, rs)
+	var sink memorySink
+	fetched, next, err := fetchRange(http.DefaultClient, []string{host}, "test-v1", &sink, 2, 0, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 3 || next != 5 {
+		t.Fatalf("fetched %d next %d, want 3 and 5", fetched, next)
+	}
+	for round := uint64(2); round < 5; round++ {
+		if sink.blocks[round] != fmt.Sprintf("block %d", round) {
+			t.Errorf("round %d: got %q", round, sink.blocks[round])
+		}
+	}
+}
+
+func TestFetchNotFoundNeedsEveryHost(t *testing.T) {
+	behind := &relayStandIn{genesisId: "test-v1", numBlocks: 2}
+	ahead := &relayStandIn{genesisId: "test-v1", numBlocks: 4}
+	hosts := []string{startRelay(t, behind), startRelay(t, ahead)}
+	var sink memorySink
+	fetched, next, err := fetchRange(http.DefaultClient, hosts, "test-v1", &sink, 0, 0, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 4 || next != 4 {
+		t.Fatalf("fetched %d next %d, want 4 and 4", fetched, next)
+	}
+}
+
+func TestFetchRetriesAcrossHosts(t *testing.T) {
+	fastRetries(t)
+	broken := &relayStandIn{genesisId: "test-v1", numBlocks: 10, failures: 1000}
+	working := &relayStandIn{genesisId: "test-v1", numBlocks: 10}
+	hosts := []string{startRelay(t, broken), startRelay(t, working)}
+	blockbytes, err := fetchBlock(http.DefaultClient, hosts, "test-v1", 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blockbytes) != "block 7" {
+		t.Fatalf("got %q", blockbytes)
+	}
+	if broken.requestCount() != 1 || working.requestCount() != 1 {
+		t.Fatalf("requests broken %d working %d, want 1 and 1", broken.requestCount(), working.requestCount())
+	}
+}
+
+func TestFetchRetryBackoff(t *testing.T) {
+	fastRetries(t)
+	flaky := &relayStandIn{genesisId: "test-v1", numBlocks: 10, failures: 2}
+	hosts := []string{startRelay(t, flaky)}
+	start := time.Now()
+	blockbytes, err := fetchBlock(http.DefaultClient, hosts, "test-v1", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blockbytes) != "block 3" || flaky.requestCount() != 3 {
+		t.Fatalf("got %q after %d requests", blockbytes, flaky.requestCount())
+	}
+	// 1ms then 2ms
+	if dt := time.Since(start); dt < 3*time.Millisecond {
+		t.Fatalf("retried after %s, expected backoff", dt)
+	}
+}
+
+func TestFetchGivesUpAfterRetries(t *testing.T) {
+	fastRetries(t)
+	broken := &relayStandIn{genesisId: "test-v1", numBlocks: 10, failures: 1000}
+	hosts := []string{startRelay(t, broken)}
+	_, err := fetchBlock(http.DefaultClient, hosts, "test-v1", 3, 2)
+	if err == nil || err == errBlockNotFound {
+		t.Fatalf("got %v, want the relay error", err)
+	}
+	if broken.requestCount() != 3 {
+		t.Fatalf("%d requests, want 3", broken.requestCount())
+	}
+}
+
+func TestFetchResumesBlockFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// 0, 1 and 3 already fetched
+	for _, name := range []string{"0", "1", "3"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("old"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err := lastBlockFileRound(dir)
+	if err != nil || last != 3 {
+		t.Fatalf("last round %d %v, want 3", last, err)
+	}
+	rs := &relayStandIn{genesisId: "test-v1", numBlocks: 6}
+	host := startRelay(t, rs)
+	fetched, next, err := fetchRange(http.DefaultClient, []string{host}, "test-v1", &blockFileSink{outdir: dir}, 0, 0, 3, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 3 || next != 6 {
+		t.Fatalf("fetched %d next %d, want 3 and 6", fetched, next)
+	}
+	for round := uint64(0); round < 6; round++ {
+		data, err := ioutil.ReadFile(filepath.Join(dir, strconv.FormatUint(round, 10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("block %d", round)
+		if round == 0 || round == 1 || round == 3 {
+			want = "old"
+		}
+		if string(data) != want {
+			t.Errorf("round %d: got %q, want %q", round, data, want)
+		}
+	}
+}
+
+func TestFetchResumesTars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rs := &relayStandIn{genesisId: "test-v1", numBlocks: 7}
+	host := startRelay(t, rs)
+
+	btw, err := newBlockTarWriter(dir, "gz", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = fetchRange(http.DefaultClient, []string{host}, "test-v1", btw, 0, 3, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = btw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := lastBlockFileRound(dir)
+	if err != nil || last != 3 {
+		t.Fatalf("last round %d %v, want 3", last, err)
+	}
+
+	// a second run picks up after the last archive
+	btw, err = newBlockTarWriter(dir, "gz", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, next, err := fetchRange(http.DefaultClient, []string{host}, "test-v1", btw, uint64(last+1), 0, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = btw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 3 || next != 7 {
+		t.Fatalf("fetched %d next %d, want 3 and 7", fetched, next)
+	}
+	for _, name := range []string{"0_1.tar.gz", "2_3.tar.gz", "4_5.tar.gz", "6_6.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	last, err = lastBlockFileRound(dir)
+	if err != nil || last != 6 {
+		t.Fatalf("last round %d %v, want 6", last, err)
+	}
+}
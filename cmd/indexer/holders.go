@@ -0,0 +1,107 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/api"
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+var (
+	holdersAsset  uint64
+	holdersRound  int64
+	holdersFormat string
+	holdersOut    string
+)
+
+var assetHoldersCmd = &cobra.Command{
+	Use:   "asset-holders",
+	Short: "export the holders of an asset at a round",
+	Long:  "export every holder of an asset and their amount as of the end of a round, as csv or ndjson.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		if holdersAsset == 0 {
+			fmt.Fprintf(os.Stderr, "need --asset\n")
+			os.Exit(1)
+			return
+		}
+		db := globalIndexerDb()
+		round := uint64(math.MaxInt64)
+		if holdersRound >= 0 {
+			round = uint64(holdersRound)
+		} else {
+			// pin the round so that the pages are consistent while accounting runs
+			stateJsonStr, err := db.GetMetastate("state")
+			maybeFail(err, "getting import state, %v\n", err)
+			if stateJsonStr != "" {
+				state, err := idb.ParseImportState(stateJsonStr)
+				maybeFail(err, "parsing import state, %v\n", err)
+				if state.AccountRound >= 0 {
+					round = uint64(state.AccountRound)
+				}
+			}
+		}
+		out := os.Stdout
+		if holdersOut != "" && holdersOut != "-" {
+			var err error
+			out, err = os.Create(holdersOut)
+			maybeFail(err, "%s: %v\n", holdersOut, err)
+			defer out.Close()
+		}
+		bout := bufio.NewWriter(out)
+		hw, err := api.NewAssetHolderWriter(bout, holdersFormat)
+		maybeFail(err, "%v\n", err)
+		var gt types.Address
+		count := 0
+		for {
+			holders, err := db.GetAssetHolders(context.Background(), holdersAsset, round, gt, 0)
+			maybeFail(err, "asset %d holders, %v\n", holdersAsset, err)
+			if len(holders) == 0 {
+				break
+			}
+			err = hw.Write(holders)
+			maybeFail(err, "%v\n", err)
+			count += len(holders)
+			gt = holders[len(holders)-1].Address
+		}
+		err = hw.Flush()
+		maybeFail(err, "%v\n", err)
+		err = bout.Flush()
+		maybeFail(err, "%v\n", err)
+		if round == math.MaxInt64 {
+			fmt.Fprintf(os.Stderr, "%d holders of asset %d\n", count, holdersAsset)
+		} else {
+			fmt.Fprintf(os.Stderr, "%d holders of asset %d at round %d\n", count, holdersAsset, round)
+		}
+	},
+}
+
+func init() {
+	assetHoldersCmd.Flags().Uint64VarP(&holdersAsset, "asset", "a", 0, "asset id")
+	assetHoldersCmd.Flags().Int64VarP(&holdersRound, "round", "r", -1, "round to take the snapshot at, default the last accounted round")
+	assetHoldersCmd.Flags().StringVarP(&holdersFormat, "format", "f", "csv", "csv or ndjson")
+	assetHoldersCmd.Flags().StringVarP(&holdersOut, "out", "o", "-", "file to write, default stdout")
+}
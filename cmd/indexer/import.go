@@ -18,13 +18,13 @@ package main
 
 import (
 	"archive/tar"
-	"compress/bzip2"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -80,25 +80,32 @@ func importTar(imp importer.Importer, tarfile io.Reader) (err error) {
 	return
 }
 
-func importFile(db idb.IndexerDb, imp importer.Importer, fname string) {
-	imported, err := db.AlreadyImported(fname)
-	maybeFail(err, "%s: %v\n", fname, err)
-	if imported {
+// pathNameEndInt parses the last round out of {first}_{last}.tar* names, -1 if it isn't one
+func pathNameEndInt(x string) int64 {
+	x = filepath.Base(x)
+	underscorePos := strings.IndexRune(x, '_')
+	dotPos := strings.Index(x, ".tar")
+	if underscorePos == -1 || dotPos < underscorePos {
+		return -1
+	}
+	v, err := strconv.ParseInt(x[underscorePos+1:dotPos], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func importFile(db idb.IndexerDb, imp importer.Importer, fname string, progress idb.ImportProgress) {
+	if end := pathNameEndInt(fname); end != -1 && end <= progress.ContiguousRound {
+		// whole file is below the import checkpoint. blocks in other files are skipped by the importer.
 		return
 	}
 	fmt.Printf("importing %s ...\n", fname)
-	if strings.HasSuffix(fname, ".tar") {
-		fin, err := os.Open(fname)
-		maybeFail(err, "%s: %v\n", fname, err)
-		defer fin.Close()
-		err = importTar(imp, fin)
-		maybeFail(err, "%s: %v\n", fname, err)
-	} else if strings.HasSuffix(fname, ".tar.bz2") {
-		fin, err := os.Open(fname)
+	if isBlockTarPath(fname) {
+		tarin, closer, err := openBlockTar(fname)
 		maybeFail(err, "%s: %v\n", fname, err)
-		defer fin.Close()
-		bzin := bzip2.NewReader(fin)
-		err = importTar(imp, bzin)
+		defer closer()
+		err = importTar(imp, tarin)
 		maybeFail(err, "%s: %v\n", fname, err)
 	} else {
 		// assume a standalone block msgpack blob
@@ -107,24 +114,62 @@ func importFile(db idb.IndexerDb, imp importer.Importer, fname string) {
 		err = imp.ImportBlock(blockbytes)
 		maybeFail(err, "%s: could not import, %v\n", fname, err)
 	}
-	err = db.MarkImported(fname)
-	maybeFail(err, "%s: %v\n", fname, err)
+	if flusher, ok := imp.(importer.Flusher); ok {
+		// report errors against the file they came from
+		err := flusher.Flush()
+		maybeFail(err, "%s: %v\n", fname, err)
+	}
 }
 
-func loadGenesis(db idb.IndexerDb, in io.Reader) (err error) {
-	var genesis types.Genesis
+func readGenesis(in io.Reader) (genesis types.Genesis, err error) {
 	gbytes, err := ioutil.ReadAll(in)
 	if err != nil {
-		return fmt.Errorf("error reading genesis, %v", err)
+		return genesis, fmt.Errorf("error reading genesis, %v", err)
 	}
 	err = json.Decode(gbytes, &genesis)
 	if err != nil {
-		return fmt.Errorf("error decoding genesis, %v", err)
+		return genesis, fmt.Errorf("error decoding genesis, %v", err)
 	}
+	return
+}
 
+func loadGenesis(db idb.IndexerDb, in io.Reader) (err error) {
+	genesis, err := readGenesis(in)
+	if err != nil {
+		return
+	}
 	return db.LoadGenesis(genesis)
 }
 
+// newBlockVerifier checks blocks against --genesis if given, otherwise against blocks already in the db
+func newBlockVerifier(db idb.IndexerDb) *importer.BlockVerifier {
+	var genesis *types.Genesis
+	if genesisJsonPath != "" {
+		gf, err := os.Open(genesisJsonPath)
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+		g, err := readGenesis(gf)
+		gf.Close()
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+		genesis = &g
+	}
+	bv, err := importer.NewBlockVerifier(db, genesis)
+	maybeFail(err, "block verifier, %v\n", err)
+	return bv
+}
+
+// reportBlockGaps prints ranges of rounds missing from the db, to find which archives still need importing
+func reportBlockGaps(db idb.IndexerDb) {
+	gaps, err := db.GetBlockGaps()
+	maybeFail(err, "getting block gaps, %v\n", err)
+	for _, gap := range gaps {
+		if gap.First == gap.Last {
+			fmt.Printf("missing round %d\n", gap.First)
+		} else {
+			fmt.Printf("missing rounds %d-%d\n", gap.First, gap.Last)
+		}
+	}
+}
+
 /*
 type ImportState struct {
 	// AccountRound is the last round committed into account state.
@@ -156,12 +201,23 @@ func updateAccounting(db idb.IndexerDb) {
 		fmt.Printf("will start from round >%d\n", state.AccountRound)
 	}
 
+	currentRound := accountTxns(db, state.AccountRound, -1)
+	fmt.Printf("accounting updated through round %d\n", currentRound)
+}
+
+// accountTxns does accounting for the txns after prevRound, through throughRound if it isn't -1, and returns the last round with txns
+func accountTxns(db idb.IndexerDb, prevRound, throughRound int64) (currentRound uint64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	lastlog := time.Now()
 	act := accounting.New(db)
-	txns := db.YieldTxns(context.Background(), state.AccountRound)
-	currentRound := uint64(0)
+	txns := db.YieldTxns(ctx, prevRound)
 	roundsSeen := 0
 	for txn := range txns {
+		maybeFail(txn.Error, "reading txns, %v\n", txn.Error)
+		if throughRound >= 0 && int64(txn.Round) > throughRound {
+			break
+		}
 		if txn.Round != currentRound {
 			prevRound := currentRound
 			roundsSeen++
@@ -176,18 +232,26 @@ func updateAccounting(db idb.IndexerDb) {
 				lastlog = now
 			}
 		}
-		err = act.AddTransaction(txn.Round, txn.Intra, txn.TxnBytes)
+		err := act.AddTransaction(txn.Round, txn.Intra, txn.TxnBytes)
 		maybeFail(err, "txn accounting r=%d i=%d, %v\n", txn.Round, txn.Intra, err)
 	}
-	err = act.Close()
+	err := act.Close()
 	maybeFail(err, "accounting close %v\n", err)
-	fmt.Printf("accounting updated through round %d\n", currentRound)
+	return
 }
 
 var (
-	genesisJsonPath string
-	numRoundsLimit  int
-	blockFileLimit  int
+	genesisJsonPath  string
+	numRoundsLimit   int
+	blockFileLimit   int
+	importWorkers    int
+	blocksPerTxn     int
+	bulkLoad         bool
+	bulkDropIndexes  bool
+	verifyBlocks     bool
+	blockDbPath      string
+	inlineAccounting bool
+	blockDbStart     int64
 )
 
 type blockTarPaths []string
@@ -230,7 +294,34 @@ var importCmd = &cobra.Command{
 		// TODO: connect to db and instantiate Importer
 		//imp := importer.NewPrintImporter()
 		db := globalIndexerDb()
-		imp := importer.NewDBImporter(db)
+		if bulkLoad || bulkDropIndexes {
+			err := db.StartBulkLoad(bulkDropIndexes)
+			maybeFail(err, "starting bulk load, %v\n", err)
+		}
+		imp := importer.NewParallelImporter(db, importWorkers, blocksPerTxn)
+		if verifyBlocks {
+			imp.SetVerifier(newBlockVerifier(db))
+		}
+		if inlineAccounting {
+			// catch up on anything imported without accounting, then account for each block as it is written
+			updateAccounting(db)
+			maxRound, err := db.GetMaxRound()
+			maybeFail(err, "getting last imported round, %v\n", err)
+			imp.SetAccounting(accounting.New(db), maxRound)
+		}
+		progress, err := db.GetImportProgress()
+		maybeFail(err, "getting import progress, %v\n", err)
+		if blockDbPath != "" {
+			start := uint64(progress.ContiguousRound + 1)
+			if blockDbStart >= 0 {
+				start = uint64(blockDbStart)
+			}
+			fmt.Printf("importing %s from round %d\n", blockDbPath, start)
+			_, err = importBlockDb(imp, blockDbPath, start)
+			maybeFail(err, "%v\n", err)
+			err = imp.Flush()
+			maybeFail(err, "%s: %v\n", blockDbPath, err)
+		}
 		for _, fname := range args {
 			matches, err := filepath.Glob(fname)
 			if err == nil {
@@ -241,15 +332,25 @@ var importCmd = &cobra.Command{
 				}
 				for _, gfname := range pathsSorted {
 					//fmt.Printf("%s ...\n", gfname)
-					importFile(db, imp, gfname)
+					importFile(db, imp, gfname, progress)
 				}
 			} else {
 				// try without passing throug glob
-				importFile(db, imp, fname)
+				importFile(db, imp, fname, progress)
 			}
 		}
+		err = imp.Close()
+		maybeFail(err, "%v\n", err)
+		fmt.Printf("imported %s\n", imp.Stats())
+		reportBlockGaps(db)
+		if bulkLoad || bulkDropIndexes {
+			err = db.EndBulkLoad()
+			maybeFail(err, "ending bulk load, %v\n", err)
+		}
 
-		updateAccounting(db)
+		if !inlineAccounting {
+			updateAccounting(db)
+		}
 	},
 }
 
@@ -257,4 +358,12 @@ func init() {
 	importCmd.Flags().StringVarP(&genesisJsonPath, "genesis", "g", "", "path to genesis.json")
 	importCmd.Flags().IntVarP(&numRoundsLimit, "num-rounds-limit", "", 0, "number of rounds to process")
 	importCmd.Flags().IntVarP(&blockFileLimit, "block-file-limit", "", 0, "number of block files to process (for debugging)")
+	importCmd.Flags().IntVarP(&importWorkers, "workers", "", runtime.NumCPU(), "number of goroutines decoding blocks")
+	importCmd.Flags().IntVarP(&blocksPerTxn, "blocks-per-txn", "", 1, "number of blocks to write in each db transaction")
+	importCmd.Flags().BoolVarP(&bulkLoad, "bulk", "", false, "load txns with COPY, faster for large imports")
+	importCmd.Flags().StringVarP(&blockDbPath, "block-db", "", "", "import from algod's ledger.block.sqlite, opened read-only")
+	importCmd.Flags().Int64VarP(&blockDbStart, "block-db-start", "", -1, "first round to read from --block-db, default after the last contiguous round imported")
+	importCmd.Flags().BoolVarP(&inlineAccounting, "inline-accounting", "", false, "update accounts as each block is written, in the same db transaction, instead of re-reading txns after import")
+	importCmd.Flags().BoolVarP(&verifyBlocks, "verify", "", false, "check each block's genesis, branch and txn root before writing it")
+	importCmd.Flags().BoolVarP(&bulkDropIndexes, "bulk-drop-indexes", "", false, "implies --bulk, drop secondary indexes during import and rebuild them after")
 }
@@ -62,6 +62,13 @@ func globalIndexerDb() idb.IndexerDb {
 func init() {
 	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(fetchCmd)
+	rootCmd.AddCommand(packCmd)
+	rootCmd.AddCommand(bootstrapAccountsCmd)
+	rootCmd.AddCommand(assetHoldersCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(reconcileCmd)
+	rootCmd.AddCommand(reaccountCmd)
 
 	rootCmd.PersistentFlags().StringVarP(&postgresAddr, "postgres", "P", "", "connection string for postgres database")
 	rootCmd.PersistentFlags().BoolVarP(&dummyIndexerDb, "dummydb", "n", false, "use dummy indexer db")
@@ -0,0 +1,171 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	packIndir         string
+	packOutdir        string
+	packBlocksPerFile int
+	packCompression   string
+	packDelete        bool
+)
+
+// blockFileRounds lists the rounds of block files in dir, sorted
+func blockFileRounds(dir string) (rounds []uint64, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		round, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		rounds = append(rounds, round)
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+	return
+}
+
+// verifyBlockTar checks that an archive reads back with the same blocks as the files it was made from
+func verifyBlockTar(path, indir string, rounds []uint64) (err error) {
+	tarin, closer, err := openBlockTar(path)
+	if err != nil {
+		return
+	}
+	defer closer()
+	tf := tar.NewReader(tarin)
+	for _, round := range rounds {
+		header, err := tf.Next()
+		if err != nil {
+			return fmt.Errorf("%s: reading entry for round %d, %v", path, round, err)
+		}
+		name := strconv.FormatUint(round, 10)
+		if header.Name != name {
+			return fmt.Errorf("%s: expected entry %s but got %s", path, name, header.Name)
+		}
+		blockbytes := make([]byte, header.Size)
+		_, err = io.ReadFull(tf, blockbytes)
+		if err != nil {
+			return fmt.Errorf("%s: reading entry %s, %v", path, name, err)
+		}
+		filebytes, err := ioutil.ReadFile(filepath.Join(indir, name))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(blockbytes, filebytes) {
+			return fmt.Errorf("%s: entry %s differs from file", path, name)
+		}
+	}
+	_, err = tf.Next()
+	if err != io.EOF {
+		return fmt.Errorf("%s: extra entries after round %d", path, rounds[len(rounds)-1])
+	}
+	return nil
+}
+
+// packBlockFiles packs block files from the start of the continuous sequence of rounds in indir into archives of blocksPerFile blocks.
+// left is the rounds of that sequence not filling a whole archive.
+func packBlockFiles(indir, outdir, compression string, blocksPerFile int, deleteFiles bool) (left []uint64, err error) {
+	rounds, err := blockFileRounds(indir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", indir, err)
+	}
+	// check continuous sequence
+	for i := 1; i < len(rounds); i++ {
+		if rounds[i] != rounds[i-1]+1 {
+			fmt.Fprintf(os.Stderr, "bad sequence: prev = %d, cur = %d, packing only through %d\n", rounds[i-1], rounds[i], rounds[i-1])
+			rounds = rounds[:i]
+			break
+		}
+	}
+	btw, err := newBlockTarWriter(outdir, compression, blocksPerFile)
+	if err != nil {
+		return
+	}
+	for len(rounds) >= blocksPerFile {
+		batch := rounds[:blocksPerFile]
+		rounds = rounds[blocksPerFile:]
+		for _, round := range batch {
+			blockbytes, err := ioutil.ReadFile(filepath.Join(indir, strconv.FormatUint(round, 10)))
+			if err != nil {
+				return nil, err
+			}
+			err = btw.AddBlock(round, blockbytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !deleteFiles {
+			continue
+		}
+		err = verifyBlockTar(btw.LastPath, indir, batch)
+		if err != nil {
+			return
+		}
+		for _, round := range batch {
+			err = os.Remove(filepath.Join(indir, strconv.FormatUint(round, 10)))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return rounds, nil
+}
+
+var packCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "pack block files into tar files",
+	Long:  "pack a dir of block files named by round into {first}_{last}.tar[.bz2|.gz|.zst] files for import. Only a continuous sequence of rounds is packed, and only full files of blocks.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		if packBlocksPerFile <= 0 {
+			fmt.Fprintf(os.Stderr, "--blocks-per-file must be positive\n")
+			os.Exit(1)
+		}
+		left, err := packBlockFiles(packIndir, packOutdir, packCompression, packBlocksPerFile, packDelete)
+		maybeFail(err, "%v\n", err)
+		if len(left) > 0 {
+			fmt.Printf("%d blocks left for a later pack, %d..%d\n", len(left), left[0], left[len(left)-1])
+		}
+	},
+}
+
+func init() {
+	packCmd.Flags().StringVarP(&packIndir, "indir", "i", ".", "dir to list for block files")
+	packCmd.Flags().StringVarP(&packOutdir, "outdir", "o", ".", "dir to store tar files")
+	packCmd.Flags().IntVarP(&packBlocksPerFile, "blocks-per-file", "", 1000, "number of blocks to put in each tar file. note that a full block is about 1 MB")
+	packCmd.Flags().StringVarP(&packCompression, "compress", "z", "bz2", "tar file compression: bz2, gz, zst or none")
+	packCmd.Flags().BoolVarP(&packDelete, "delete", "", false, "delete block files that have been archived to tar files, after checking the tar file reads back")
+}
@@ -0,0 +1,217 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testBlockBytes(round uint64) []byte {
+	return []byte(fmt.Sprintf("block %d", round))
+}
+
+func writeBlockFiles(t *testing.T, dir string, rounds ...uint64) {
+	for _, round := range rounds {
+		err := ioutil.WriteFile(filepath.Join(dir, strconv.FormatUint(round, 10)), testBlockBytes(round), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func roundRange(first, last uint64) (rounds []uint64) {
+	for round := first; round <= last; round++ {
+		rounds = append(rounds, round)
+	}
+	return
+}
+
+func dirNames(t *testing.T, dir string) (names []string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return
+}
+
+// readTarRounds reads an archive back as the rounds of its entries, checking each entry's contents
+func readTarRounds(t *testing.T, path string) (rounds []uint64) {
+	in, closer, err := openBlockTar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+	tf := tar.NewReader(in)
+	for {
+		header, err := tf.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		round, err := strconv.ParseUint(header.Name, 10, 64)
+		if err != nil {
+			t.Fatalf("%s: entry %s", path, header.Name)
+		}
+		blockbytes, err := ioutil.ReadAll(tf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(blockbytes) != string(testBlockBytes(round)) {
+			t.Errorf("%s: entry %s is %#v", path, header.Name, string(blockbytes))
+		}
+		rounds = append(rounds, round)
+	}
+}
+
+func TestBlockFileRounds(t *testing.T) {
+	dir := t.TempDir()
+	writeBlockFiles(t, dir, 10, 2, 3, 100)
+	for _, name := range []string{"notes.txt", "12x", ".7_partial.tar", "0_1.tar"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Mkdir(filepath.Join(dir, "5"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds, err := blockFileRounds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 3, 10, 100}; !reflect.DeepEqual(rounds, want) {
+		t.Errorf("got %v, want %v", rounds, want)
+	}
+	_, err = blockFileRounds(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("missing dir listed")
+	}
+}
+
+func TestPackBlockFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		rounds        []uint64
+		blocksPerFile int
+		compression   string
+		deleteFiles   bool
+		tars          map[string][]uint64
+		left          []uint64
+		// block files still in indir
+		kept []uint64
+	}{
+		{
+			"contiguous", roundRange(0, 6), 3, "none", false,
+			map[string][]uint64{"0_2.tar": roundRange(0, 2), "3_5.tar": roundRange(3, 5)},
+			[]uint64{6}, roundRange(0, 6),
+		},
+		{
+			"stops at gap", append(roundRange(0, 4), roundRange(6, 9)...), 2, "gz", true,
+			map[string][]uint64{"0_1.tar.gz": roundRange(0, 1), "2_3.tar.gz": roundRange(2, 3)},
+			[]uint64{4}, append([]uint64{4}, roundRange(6, 9)...),
+		},
+		{
+			"gap right after a full file", append(roundRange(100, 104), 106), 5, "zst", true,
+			map[string][]uint64{"100_104.tar.zst": roundRange(100, 104)},
+			[]uint64{}, []uint64{106},
+		},
+		{
+			"not enough for a file", roundRange(0, 2), 10, "bz2", true,
+			map[string][]uint64{},
+			roundRange(0, 2), roundRange(0, 2),
+		},
+	}
+	for _, tc := range tests {
+		indir, outdir := t.TempDir(), t.TempDir()
+		writeBlockFiles(t, indir, tc.rounds...)
+		left, err := packBlockFiles(indir, outdir, tc.compression, tc.blocksPerFile, tc.deleteFiles)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(left, tc.left) {
+			t.Errorf("%s: left %v, want %v", tc.name, left, tc.left)
+		}
+		tars := make(map[string][]uint64)
+		for _, name := range dirNames(t, outdir) {
+			tars[name] = readTarRounds(t, filepath.Join(outdir, name))
+		}
+		if !reflect.DeepEqual(tars, tc.tars) {
+			t.Errorf("%s: wrote %v, want %v", tc.name, tars, tc.tars)
+		}
+		kept, err := blockFileRounds(indir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(kept, tc.kept) {
+			t.Errorf("%s: kept %v, want %v", tc.name, kept, tc.kept)
+		}
+	}
+}
+
+func TestVerifyBlockTar(t *testing.T) {
+	indir, outdir := t.TempDir(), t.TempDir()
+	writeBlockFiles(t, indir, roundRange(0, 3)...)
+	_, err := packBlockFiles(indir, outdir, "gz", 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(outdir, "0_2.tar.gz")
+	err = verifyBlockTar(path, indir, roundRange(0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		rounds []uint64
+		err    string
+	}{
+		{"fewer rounds", roundRange(0, 1), "extra entries after round 1"},
+		{"more rounds", roundRange(0, 3), "reading entry for round 3"},
+		{"different rounds", roundRange(1, 3), "expected entry 1 but got 0"},
+	}
+	for _, tc := range tests {
+		err = verifyBlockTar(path, indir, tc.rounds)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want %s", tc.name, err, tc.err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(indir, "1"), []byte("changed"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyBlockTar(path, indir, roundRange(0, 2))
+	if err == nil || !strings.Contains(err.Error(), "entry 1 differs from file") {
+		t.Errorf("changed file: got %v", err)
+	}
+}
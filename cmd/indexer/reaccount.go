@@ -0,0 +1,102 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/idb"
+)
+
+var (
+	reaccountRound int64
+	reaccountForce bool
+)
+
+// accountRound is metastate "state" AccountRound, -1 if there is none
+func accountRound(db idb.IndexerDb) int64 {
+	stateJsonStr, err := db.GetMetastate("state")
+	maybeFail(err, "getting import state, %v\n", err)
+	if stateJsonStr == "" {
+		return -1
+	}
+	state, err := idb.ParseImportState(stateJsonStr)
+	maybeFail(err, "parsing import state, %v\n", err)
+	return state.AccountRound
+}
+
+var reaccountCmd = &cobra.Command{
+	Use:   "reaccount",
+	Short: "rebuild account state from the txn table",
+	Long:  "rebuild account, asset and the other tables accounting writes by loading genesis and replaying txns into new tables, then swap them in. The api keeps serving the old tables until the swap.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		if genesisJsonPath == "" {
+			fmt.Fprintf(os.Stderr, "need --genesis genesis.json to rebuild from\n")
+			os.Exit(1)
+			return
+		}
+		db := globalIndexerDb()
+		reaccounter, ok := db.(idb.Reaccounter)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "db can't rebuild account state\n")
+			os.Exit(1)
+			return
+		}
+		shadow, err := reaccounter.StartReaccount()
+		maybeFail(err, "starting rebuild, %v\n", err)
+		gf, err := os.Open(genesisJsonPath)
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+		err = loadGenesis(shadow, gf)
+		gf.Close()
+		maybeFail(err, "%s: could not load genesis json, %v\n", genesisJsonPath, err)
+
+		accountTxns(shadow, -1, reaccountRound)
+		for {
+			rebuilt := accountRound(shadow)
+			err = reaccounter.FinishReaccount(reaccountForce)
+			if err == nil {
+				fmt.Printf("account state rebuilt through round %d\n", rebuilt)
+				return
+			}
+			if err != idb.ErrReaccountBehind {
+				maybeFail(err, "swapping in rebuilt account state, %v\n", err)
+			}
+			if reaccountRound >= 0 {
+				fmt.Fprintf(os.Stderr, "accounting is at round %d, use --force to roll it back to round %d\n", accountRound(db), rebuilt)
+				os.Exit(1)
+				return
+			}
+			// live accounting moved on during the rebuild
+			accountTxns(shadow, rebuilt, -1)
+			if accountRound(shadow) == rebuilt {
+				fmt.Fprintf(os.Stderr, "accounting is at round %d but the txns only rebuild through round %d, use --force to swap anyway\n", accountRound(db), rebuilt)
+				os.Exit(1)
+				return
+			}
+		}
+	},
+}
+
+func init() {
+	reaccountCmd.Flags().StringVarP(&genesisJsonPath, "genesis", "g", "", "path to genesis.json the db was started from")
+	reaccountCmd.Flags().Int64VarP(&reaccountRound, "round", "r", -1, "rebuild through this round, default all txns")
+	reaccountCmd.Flags().BoolVarP(&reaccountForce, "force", "", false, "swap in the rebuilt state even if accounting had got further")
+}
@@ -0,0 +1,247 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	atypes "github.com/algorand/go-algorand-sdk/types"
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+var (
+	reconcileTrackerPath string
+	reconcileDumpPath    string
+	reconcileRound       int64
+	reconcileAnyRound    bool
+)
+
+// balances is what reconcile compares: algos, asset amounts and frozen flags
+type balances struct {
+	MicroAlgos uint64
+	Assets     map[uint64]types.AssetHolding
+}
+
+func accountBalances(ad types.AccountData) balances {
+	b := balances{MicroAlgos: uint64(ad.MicroAlgos), Assets: make(map[uint64]types.AssetHolding, len(ad.Assets))}
+	for assetid, holding := range ad.Assets {
+		b.Assets[uint64(assetid)] = holding
+	}
+	return b
+}
+
+func (b balances) isZero() bool {
+	return b.MicroAlgos == 0 && len(b.Assets) == 0
+}
+
+// trackerDumpLine is a line of tracker_balance_dump.py output
+type trackerDumpLine struct {
+	Addr   string            `json:"addr"`
+	Values map[string]uint64 `json:"v"`
+	Frozen map[string]bool   `json:"f"`
+}
+
+// readTrackerDump reads the json lines of tracker_balance_dump.py
+func readTrackerDump(in io.Reader) (expected map[types.Address]balances, err error) {
+	expected = make(map[types.Address]balances)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line trackerDumpLine
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d, %v", lineno, err)
+		}
+		addr, err := atypes.DecodeAddress(line.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d bad addr %#v, %v", lineno, line.Addr, err)
+		}
+		b := balances{Assets: make(map[uint64]types.AssetHolding)}
+		for k, v := range line.Values {
+			if k == "algo" {
+				b.MicroAlgos = v
+				continue
+			}
+			assetid, err := strconv.ParseUint(k, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d bad asset id %#v, %v", lineno, k, err)
+			}
+			b.Assets[assetid] = types.AssetHolding{Amount: v, Frozen: line.Frozen[k]}
+		}
+		expected[addr] = b
+	}
+	err = scanner.Err()
+	return
+}
+
+// balanceDiffs describes how the db differs from what algod has
+func balanceDiffs(algod, indexer balances) (diffs []string) {
+	if algod.MicroAlgos != indexer.MicroAlgos {
+		diffs = append(diffs, fmt.Sprintf("microalgos algod %d, indexer %d", algod.MicroAlgos, indexer.MicroAlgos))
+	}
+	for assetid, ah := range algod.Assets {
+		ih, ok := indexer.Assets[assetid]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d holding missing from indexer", assetid))
+			continue
+		}
+		if ah.Amount != ih.Amount {
+			diffs = append(diffs, fmt.Sprintf("asset %d algod %d, indexer %d", assetid, ah.Amount, ih.Amount))
+		}
+		if ah.Frozen != ih.Frozen {
+			diffs = append(diffs, fmt.Sprintf("asset %d frozen algod %v, indexer %v", assetid, ah.Frozen, ih.Frozen))
+		}
+	}
+	for assetid := range indexer.Assets {
+		if _, ok := algod.Assets[assetid]; !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d holding not in algod", assetid))
+		}
+	}
+	return
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "compare balances with an algod tracker db or dump",
+	Long:  "compare algos, asset amounts and frozen flags in account and account_asset with algod's ledger.tracker.sqlite or tracker_balance_dump.py output. Exits 1 if any account differs, or if the indexer accounts are not as of the same round unless --any-round.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		var expected map[types.Address]balances
+		// round the algod balances are as of, -1 if unknown
+		expectedRound := reconcileRound
+		if reconcileTrackerPath != "" {
+			round, accounts, err := readTrackerAccounts(reconcileTrackerPath)
+			maybeFail(err, "%v\n", err)
+			if reconcileRound >= 0 && uint64(reconcileRound) != round {
+				fmt.Fprintf(os.Stderr, "%s: has accounts as of round %d, not %d\n", reconcileTrackerPath, round, reconcileRound)
+				os.Exit(1)
+				return
+			}
+			expectedRound = int64(round)
+			expected = make(map[types.Address]balances, len(accounts))
+			for _, acct := range accounts {
+				expected[acct.Address] = accountBalances(acct.Data)
+			}
+			fmt.Printf("%s: %d accounts as of round %d\n", reconcileTrackerPath, len(accounts), round)
+		} else if reconcileDumpPath != "" {
+			var in io.Reader = os.Stdin
+			if reconcileDumpPath != "-" {
+				f, err := os.Open(reconcileDumpPath)
+				maybeFail(err, "%s: %v\n", reconcileDumpPath, err)
+				defer f.Close()
+				in = f
+			}
+			var err error
+			expected, err = readTrackerDump(in)
+			maybeFail(err, "%s: %v\n", reconcileDumpPath, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "need --tracker ledger.tracker.sqlite or --dump tracker_balance_dump.py output\n")
+			os.Exit(1)
+			return
+		}
+
+		db := globalIndexerDb()
+		stateJsonStr, err := db.GetMetastate("state")
+		maybeFail(err, "getting import state, %v\n", err)
+		accountRound := int64(-1)
+		if stateJsonStr != "" {
+			state, err := idb.ParseImportState(stateJsonStr)
+			maybeFail(err, "parsing import state, %v\n", err)
+			accountRound = state.AccountRound
+		}
+		fmt.Printf("indexer accounts as of round %d\n", accountRound)
+		if !reconcileAnyRound {
+			// balances from different rounds differ for every account that moved in between
+			if expectedRound < 0 {
+				fmt.Fprintf(os.Stderr, "need --round the dump is as of, or --any-round\n")
+				os.Exit(1)
+				return
+			}
+			if expectedRound != accountRound {
+				fmt.Fprintf(os.Stderr, "algod balances are as of round %d but indexer accounts are as of round %d, use --any-round to compare anyway\n", expectedRound, accountRound)
+				os.Exit(1)
+				return
+			}
+		}
+
+		diffs := make(map[types.Address][]string)
+		match := 0
+		for row := range db.YieldAccounts(context.Background()) {
+			maybeFail(row.Error, "reading accounts, %v\n", row.Error)
+			indexer := accountBalances(row.Data)
+			algod, ok := expected[row.Address]
+			if !ok {
+				// algod drops accounts with nothing in them, the indexer keeps them
+				if !indexer.isZero() {
+					diffs[row.Address] = []string{"not in algod"}
+				}
+				continue
+			}
+			delete(expected, row.Address)
+			d := balanceDiffs(algod, indexer)
+			if len(d) > 0 {
+				diffs[row.Address] = d
+			} else {
+				match++
+			}
+		}
+		for addr := range expected {
+			diffs[addr] = []string{"missing from indexer"}
+		}
+
+		addrs := make([]string, 0, len(diffs))
+		byString := make(map[string][]string, len(diffs))
+		for addr, d := range diffs {
+			s := atypes.Address(addr).String()
+			addrs = append(addrs, s)
+			byString[s] = d
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			fmt.Printf("%s:\n", addr)
+			for _, d := range byString[addr] {
+				fmt.Printf("\t%s\n", d)
+			}
+		}
+		fmt.Printf("%d accounts match, %d differ\n", match, len(diffs))
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	reconcileCmd.Flags().StringVarP(&reconcileTrackerPath, "tracker", "f", "", "algod ledger.tracker.sqlite, opened read-only")
+	reconcileCmd.Flags().StringVarP(&reconcileDumpPath, "dump", "d", "", "json lines from tracker_balance_dump.py, - for stdin")
+	reconcileCmd.Flags().Int64VarP(&reconcileRound, "round", "r", -1, "round the dump is as of, default whatever round the tracker db is at")
+	reconcileCmd.Flags().BoolVarP(&reconcileAnyRound, "any-round", "", false, "compare even if algod and the indexer are not at the same round")
+}
@@ -0,0 +1,309 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+
+	atypes "github.com/algorand/go-algorand-sdk/types"
+	"github.com/spf13/cobra"
+
+	"github.com/algorand/indexer/accounting"
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+var verifySupply bool
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check account state against a replay of all txns",
+	Long:  "replay every txn from genesis into accounting held in memory and compare the result with account, account_asset and asset. Exits 1 if anything differs.",
+	//Args:
+	Run: func(cmd *cobra.Command, args []string) {
+		if genesisJsonPath == "" {
+			fmt.Fprintf(os.Stderr, "need --genesis genesis.json to replay from\n")
+			os.Exit(1)
+			return
+		}
+		gf, err := os.Open(genesisJsonPath)
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+		genesis, err := readGenesis(gf)
+		gf.Close()
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+
+		db := globalIndexerDb()
+		stateJsonStr, err := db.GetMetastate("state")
+		maybeFail(err, "getting import state, %v\n", err)
+		if stateJsonStr == "" {
+			fmt.Fprintf(os.Stderr, "no account state to verify\n")
+			os.Exit(1)
+			return
+		}
+		state, err := idb.ParseImportState(stateJsonStr)
+		maybeFail(err, "parsing import state, %v\n", err)
+
+		ledger := accounting.NewLedger()
+		err = ledger.LoadGenesis(genesis)
+		maybeFail(err, "%s: %v\n", genesisJsonPath, err)
+		err = replayLedger(db, ledger, state.AccountRound)
+		maybeFail(err, "replay, %v\n", err)
+
+		diffs, total, err := diffLedger(db, ledger)
+		maybeFail(err, "comparing accounts, %v\n", err)
+		ok := len(diffs) == 0
+		if !ok {
+			firstRounds, err := findDivergence(db, genesis, state.AccountRound, diffs)
+			maybeFail(err, "finding where accounts diverge, %v\n", err)
+			reportDiffs(diffs, firstRounds)
+		}
+		fmt.Printf("%d accounts differ through round %d\n", len(diffs), state.AccountRound)
+		if verifySupply {
+			supplyOk, err := checkSupply(db, genesis, state.AccountRound, total)
+			maybeFail(err, "supply, %v\n", err)
+			ok = ok && supplyOk
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+// replayLedger does accounting into ledger for every txn through throughRound
+func replayLedger(db idb.IndexerDb, ledger *accounting.Ledger, throughRound int64) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	act := accounting.NewInMemory(db, ledger)
+	for txn := range db.YieldTxns(ctx, -1) {
+		if txn.Error != nil {
+			return txn.Error
+		}
+		if int64(txn.Round) > throughRound {
+			break
+		}
+		err := act.AddTransaction(txn.Round, txn.Intra, txn.TxnBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return act.Close()
+}
+
+func holdingDiffs(mem, dbh map[types.AssetIndex]types.AssetHolding) (diffs []string) {
+	for assetid, mh := range mem {
+		dh, ok := dbh[assetid]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d holding missing from db", assetid))
+		} else if mh != dh {
+			diffs = append(diffs, fmt.Sprintf("asset %d holding replay %d frozen=%v, db %d frozen=%v", assetid, mh.Amount, mh.Frozen, dh.Amount, dh.Frozen))
+		}
+	}
+	for assetid := range dbh {
+		if _, ok := mem[assetid]; !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d holding not in replay", assetid))
+		}
+	}
+	return
+}
+
+// accountDiffs describes how an account in the db differs from the replay
+func accountDiffs(mem *accounting.LedgerAccount, dba idb.AccountStateRow) (diffs []string) {
+	m := &mem.Data
+	d := &dba.Data
+	if m.MicroAlgos != d.MicroAlgos {
+		diffs = append(diffs, fmt.Sprintf("microalgos replay %d, db %d", m.MicroAlgos, d.MicroAlgos))
+	}
+	if m.RewardsBase != d.RewardsBase {
+		diffs = append(diffs, fmt.Sprintf("rewardsbase replay %d, db %d", m.RewardsBase, d.RewardsBase))
+	}
+	if m.Status != d.Status {
+		diffs = append(diffs, fmt.Sprintf("status replay %s, db %s", types.StatusString(m.Status), types.StatusString(d.Status)))
+	}
+	if m.VoteID != d.VoteID || m.SelectionID != d.SelectionID || m.VoteFirstValid != d.VoteFirstValid || m.VoteLastValid != d.VoteLastValid || m.VoteKeyDilution != d.VoteKeyDilution {
+		diffs = append(diffs, "participation keys differ")
+	}
+	if mem.Deleted != dba.Deleted {
+		diffs = append(diffs, fmt.Sprintf("deleted replay %v, db %v", mem.Deleted, dba.Deleted))
+	}
+	diffs = append(diffs, holdingDiffs(m.Assets, d.Assets)...)
+	for assetid, mp := range m.AssetParams {
+		dp, ok := d.AssetParams[assetid]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d params missing from db", assetid))
+		} else if mp != dp {
+			diffs = append(diffs, fmt.Sprintf("asset %d params differ", assetid))
+		}
+	}
+	for assetid := range d.AssetParams {
+		if _, ok := m.AssetParams[assetid]; !ok {
+			diffs = append(diffs, fmt.Sprintf("asset %d params not in replay", assetid))
+		}
+	}
+	return
+}
+
+// diffLedger compares every account in the db with ledger. total is the microalgos in the db.
+func diffLedger(db idb.IndexerDb, ledger *accounting.Ledger) (diffs map[types.Address][]string, total uint64, err error) {
+	diffs = make(map[types.Address][]string)
+	seen := make(map[types.Address]bool, len(ledger.Accounts))
+	for row := range db.YieldAccounts(context.Background()) {
+		if row.Error != nil {
+			return nil, 0, row.Error
+		}
+		seen[row.Address] = true
+		total += uint64(row.Data.MicroAlgos)
+		mem, ok := ledger.Accounts[row.Address]
+		if !ok {
+			diffs[row.Address] = []string{"not in replay"}
+			continue
+		}
+		ad := accountDiffs(mem, row)
+		if len(ad) > 0 {
+			diffs[row.Address] = ad
+		}
+	}
+	for addr := range ledger.Accounts {
+		if !seen[addr] {
+			diffs[addr] = []string{"missing from db"}
+		}
+	}
+	return
+}
+
+// historyDiffs describes how an account as of a round in account_history differs from the replay at that round
+func historyDiffs(mem *accounting.LedgerAccount, hist idb.Account) (diffs []string) {
+	if uint64(mem.Data.MicroAlgos) != hist.AmountWithoutPendingRewards {
+		diffs = append(diffs, "microalgos")
+	}
+	if types.StatusString(mem.Data.Status) != hist.Status {
+		diffs = append(diffs, "status")
+	}
+	dbh := make(map[types.AssetIndex]types.AssetHolding, len(hist.Assets))
+	for assetid, holding := range hist.Assets {
+		dbh[types.AssetIndex(assetid)] = types.AssetHolding{Amount: holding.Amount, Frozen: holding.Frozen}
+	}
+	return append(diffs, holdingDiffs(mem.Data.Assets, dbh)...)
+}
+
+// findDivergence replays again watching the accounts that differ, and compares them with their history each round the replay changes them.
+// The result is the first such round each account differs at, accounts without history at that point are left out.
+func findDivergence(db idb.IndexerDb, genesis types.Genesis, throughRound int64, diffs map[types.Address][]string) (firstRounds map[types.Address]uint64, err error) {
+	firstRounds = make(map[types.Address]uint64)
+	ledger := accounting.NewLedger()
+	err = ledger.LoadGenesis(genesis)
+	if err != nil {
+		return
+	}
+	var histErr error
+	check := func(round uint64, addr types.Address, acct *accounting.LedgerAccount) {
+		if _, watched := diffs[addr]; !watched || histErr != nil {
+			return
+		}
+		if _, found := firstRounds[addr]; found {
+			return
+		}
+		hist, err := db.GetAccountAtRound(context.Background(), addr, round)
+		if err == sql.ErrNoRows {
+			// no history, e.g. accounted before history was recorded
+			return
+		}
+		if err != nil {
+			histErr = err
+			return
+		}
+		if len(historyDiffs(acct, hist)) > 0 {
+			firstRounds[addr] = round
+		}
+	}
+	for addr, acct := range ledger.Accounts {
+		check(0, addr, acct)
+	}
+	ledger.OnChange = check
+	err = replayLedger(db, ledger, throughRound)
+	if err == nil {
+		err = histErr
+	}
+	return
+}
+
+func reportDiffs(diffs map[types.Address][]string, firstRounds map[types.Address]uint64) {
+	addrs := make([]types.Address, 0, len(diffs))
+	for addr := range diffs {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return atypes.Address(addrs[i]).String() < atypes.Address(addrs[j]).String() })
+	for _, addr := range addrs {
+		if round, ok := firstRounds[addr]; ok {
+			fmt.Printf("%s: first differs at round %d\n", atypes.Address(addr).String(), round)
+		} else {
+			fmt.Printf("%s: differs\n", atypes.Address(addr).String())
+		}
+		for _, d := range diffs[addr] {
+			fmt.Printf("\t%s\n", d)
+		}
+	}
+}
+
+// checkSupply checks that the money in the db is what genesis started with.
+// Pending rewards are still in the rewards pool until they are realized, so balances plus pending rewards outside the pool and what the pool has left over them add up to the genesis total.
+func checkSupply(db idb.IndexerDb, genesis types.Genesis, round int64, total uint64) (ok bool, err error) {
+	genesisTotal := uint64(0)
+	for _, alloc := range genesis.Allocation {
+		genesisTotal += uint64(alloc.State.MicroAlgos)
+	}
+	if round < 0 {
+		return total == genesisTotal, nil
+	}
+	block, err := db.GetBlock(uint64(round))
+	if err != nil {
+		return false, fmt.Errorf("block %d, %v", round, err)
+	}
+	pending := uint64(0)
+	poolBalance := uint64(0)
+	for row := range db.YieldAccounts(context.Background()) {
+		if row.Error != nil {
+			return false, row.Error
+		}
+		if row.Address == block.RewardsPool {
+			poolBalance = uint64(row.Data.MicroAlgos)
+			continue
+		}
+		pending += uint64(types.PendingRewards(row.Data.Status, row.Data.MicroAlgos, row.Data.RewardsBase, block.RewardsLevel))
+	}
+	ok = true
+	if total != genesisTotal {
+		fmt.Printf("supply: accounts have %d microalgos, genesis had %d\n", total, genesisTotal)
+		ok = false
+	}
+	if pending > poolBalance {
+		fmt.Printf("supply: %d microalgos of pending rewards but the rewards pool has %d\n", pending, poolBalance)
+		ok = false
+	}
+	if ok {
+		fmt.Printf("supply ok: %d microalgos balances and pending rewards outside the rewards pool, %d left in the pool, %d total as of round %d\n", total-poolBalance+pending, poolBalance-pending, total, round)
+	}
+	return
+}
+
+func init() {
+	verifyCmd.Flags().StringVarP(&genesisJsonPath, "genesis", "g", "", "path to genesis.json the db was started from")
+	verifyCmd.Flags().BoolVarP(&verifySupply, "supply", "", false, "also check that balances plus pending rewards add up to the genesis total")
+}
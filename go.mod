@@ -5,8 +5,12 @@ go 1.12
 require (
 	github.com/algorand/go-algorand-sdk v1.2.1
 	github.com/algorand/go-codec v1.1.7 // indirect
+	github.com/dsnet/compress v0.0.1
 	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/gorilla/mux v1.7.4
+	github.com/klauspost/compress v1.10.3
 	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/spf13/cobra v0.0.5
 	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/crypto v0.0.0-20200117160349-530e935923ad // indirect
@@ -18,10 +18,12 @@ package idb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/algorand/go-algorand-sdk/client/algod/models"
+	"github.com/algorand/go-algorand-sdk/encoding/json"
 
 	"github.com/algorand/indexer/types"
 )
@@ -41,15 +43,37 @@ func (db *dummyIndexerDb) AddTransaction(round uint64, intra int, txtypeenum int
 	fmt.Printf("\ttxn %d %d %d %d\n", round, intra, txtypeenum, assetid)
 	return nil
 }
+func (db *dummyIndexerDb) AddBlockHeader(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
+	fmt.Printf("AddBlockHeader %d %d %d header bytes\n", round, timestamp, len(headerbytes))
+	return nil
+}
 func (db *dummyIndexerDb) CommitBlock(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
 	fmt.Printf("CommitBlock %d %d %d header bytes\n", round, timestamp, len(headerbytes))
 	return nil
 }
 
-func (db *dummyIndexerDb) AlreadyImported(path string) (imported bool, err error) {
+func (db *dummyIndexerDb) GetMaxRound() (round int64, err error) {
+	return -1, nil
+}
+
+func (db *dummyIndexerDb) StartBulkLoad(dropIndexes bool) (err error) {
+	return nil
+}
+func (db *dummyIndexerDb) EndBulkLoad() (err error) {
+	return nil
+}
+
+func (db *dummyIndexerDb) GetImportProgress() (progress ImportProgress, err error) {
+	return ImportProgress{ContiguousRound: -1}, nil
+}
+func (db *dummyIndexerDb) HasBlock(round uint64) (have bool, err error) {
 	return false, nil
 }
-func (db *dummyIndexerDb) MarkImported(path string) (err error) {
+func (db *dummyIndexerDb) GetBlockGaps() (gaps []BlockGap, err error) {
+	return nil, nil
+}
+
+func (db *dummyIndexerDb) LoadAccounts(round, txnCounter uint64, accounts []AccountState) (err error) {
 	return nil
 }
 
@@ -69,10 +93,28 @@ func (db *dummyIndexerDb) YieldTxns(ctx context.Context, prevRound int64) <-chan
 	return nil
 }
 
+func (db *dummyIndexerDb) YieldAccounts(ctx context.Context) <-chan AccountStateRow {
+	results := make(chan AccountStateRow)
+	close(results)
+	return results
+}
+
+func (db *dummyIndexerDb) AddRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (err error) {
+	return nil
+}
+
 func (db *dummyIndexerDb) CommitRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (err error) {
 	return nil
 }
 
+func (db *dummyIndexerDb) GetAccountTotals(round uint64) (totals AccountTotals, err error) {
+	return
+}
+
+func (db *dummyIndexerDb) GetDefaultFrozen() (defaultFrozen map[uint64]bool, err error) {
+	return make(map[uint64]bool), nil
+}
+
 func (db *dummyIndexerDb) GetBlock(round uint64) (block types.Block, err error) {
 	err = nil
 	return
@@ -81,10 +123,145 @@ func (db *dummyIndexerDb) TransactionsForAddress(ctx context.Context, addr types
 	return nil
 }
 
-func (db *dummyIndexerDb) GetAccounts(ctx context.Context, greaterThan types.Address, limit int) (accounts []models.Account, err error) {
+func (db *dummyIndexerDb) GetAccounts(ctx context.Context, greaterThan types.Address, limit int, includeClosed bool) (accounts []Account, err error) {
+	return nil, nil
+}
+
+func (db *dummyIndexerDb) GetAccountAtRound(ctx context.Context, addr types.Address, round uint64) (account Account, err error) {
+	return
+}
+
+func (db *dummyIndexerDb) GetAssetHolders(ctx context.Context, assetid, round uint64, greaterThan types.Address, limit int) (holders []AssetHolder, err error) {
 	return nil, nil
 }
 
+// ImportProgress is metastate "import"
+type ImportProgress struct {
+	// ContiguousRound is the highest round such that it and every round before it are imported. -1 for none.
+	ContiguousRound int64 `codec:"contiguous_round"`
+}
+
+// Account is the api's account, models.Account plus the participation keys of an online account
+type Account struct {
+	models.Account
+	Participation *Participation `json:"participation,omitempty"`
+}
+
+// Participation is like algod's, models.Account only has it in newer sdk versions
+type Participation struct {
+	ParticipationPK []byte `json:"partpkb64"`
+	VRFPK           []byte `json:"vrfpkb64"`
+	VoteFirst       uint64 `json:"votefst"`
+	VoteLast        uint64 `json:"votelst"`
+	VoteKeyDilution uint64 `json:"votekd"`
+}
+
+// AccountState is an account's full state from outside the indexer, e.g. an algod tracker db
+type AccountState struct {
+	Address types.Address
+	Data    types.AccountData
+}
+
+// AccountStateRow is an account from YieldAccounts
+type AccountStateRow struct {
+	AccountState
+	// Deleted is true for an account that was closed and not reopened
+	Deleted bool
+	Error   error
+}
+
+// AssetHolder is one account's holding of an asset
+type AssetHolder struct {
+	Address types.Address
+	Amount  uint64
+	Frozen  bool
+}
+
+// MaxAssetHoldersLimit is the most holders GetAssetHolders returns at once
+const MaxAssetHoldersLimit = 10000
+
+// AssetHoldersLimit is the page size GetAssetHolders uses for limit
+func AssetHoldersLimit(limit int) int {
+	if limit <= 0 || limit > MaxAssetHoldersLimit {
+		return MaxAssetHoldersLimit
+	}
+	return limit
+}
+
+// ImportState is metastate "state"
+type ImportState struct {
+	// AccountRound is the last round committed into account state. -1 for none.
+	AccountRound int64 `codec:"account_round"`
+	// TxnCounter is the block header TxnCounter at the end of AccountRound, which new asset ids are derived from
+	TxnCounter uint64 `codec:"txn_counter"`
+	// Totals as of AccountRound, nil until first computed
+	Totals *AccountTotals `codec:"totals"`
+}
+
+// AlgoCount is the money and reward units of the accounts with one Status
+type AlgoCount struct {
+	Money       uint64 `codec:"money" json:"money"`
+	RewardUnits uint64 `codec:"units" json:"rewardUnits"`
+}
+
+// AccountTotals is like go-algorand ledger AccountTotals.
+// Money is what is in account.microalgos. Pending rewards stay in the rewards pool until an account realizes them, so the sum of Money is the total supply.
+type AccountTotals struct {
+	Round            uint64    `codec:"round" json:"round"`
+	Online           AlgoCount `codec:"online" json:"online"`
+	Offline          AlgoCount `codec:"offline" json:"offline"`
+	NotParticipating AlgoCount `codec:"notpart" json:"notParticipating"`
+	RewardsLevel     uint64    `codec:"rwd" json:"rewardsLevel"`
+}
+
+// Money is the total supply
+func (at AccountTotals) Money() uint64 {
+	return at.Online.Money + at.Offline.Money + at.NotParticipating.Money
+}
+
+// Class returns the counts for accounts with status
+func (at *AccountTotals) Class(status byte) *AlgoCount {
+	switch status {
+	case types.Online:
+		return &at.Online
+	case types.NotParticipating:
+		return &at.NotParticipating
+	default:
+		return &at.Offline
+	}
+}
+
+// Add adds the money and reward units of b
+func (at *AccountTotals) Add(b AccountTotals) {
+	at.Online.Money += b.Online.Money
+	at.Online.RewardUnits += b.Online.RewardUnits
+	at.Offline.Money += b.Offline.Money
+	at.Offline.RewardUnits += b.Offline.RewardUnits
+	at.NotParticipating.Money += b.NotParticipating.Money
+	at.NotParticipating.RewardUnits += b.NotParticipating.RewardUnits
+}
+
+// Sub subtracts the money and reward units of b
+func (at *AccountTotals) Sub(b AccountTotals) {
+	at.Online.Money -= b.Online.Money
+	at.Online.RewardUnits -= b.Online.RewardUnits
+	at.Offline.Money -= b.Offline.Money
+	at.Offline.RewardUnits -= b.Offline.RewardUnits
+	at.NotParticipating.Money -= b.NotParticipating.Money
+	at.NotParticipating.RewardUnits -= b.NotParticipating.RewardUnits
+}
+
+func ParseImportState(js string) (istate ImportState, err error) {
+	err = json.Decode([]byte(js), &istate)
+	return
+}
+
+// BlockGap is a range of missing rounds, inclusive
+type BlockGap struct {
+	First uint64
+	Last  uint64
+}
+
 type IndexerFactory interface {
 	Name() string
 	Build(arg string) (IndexerDb, error)
@@ -103,25 +280,64 @@ type IndexerDb interface {
 	StartBlock() error
 	AddTransaction(round uint64, intra int, txtypeenum int, assetid uint64, txnbytes []byte, txn types.SignedTxnInBlock, participation [][]byte) error
 	CommitBlock(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error
+	// AddBlockHeader writes a block header without committing so that several blocks can go in one StartBlock()...CommitBlock() transaction.
+	AddBlockHeader(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error
+
+	// StartBulkLoad switches to a faster way of writing txns for large imports, optionally dropping indexes until EndBulkLoad
+	StartBulkLoad(dropIndexes bool) error
+	EndBulkLoad() error
 
-	AlreadyImported(path string) (imported bool, err error)
-	MarkImported(path string) (err error)
+	// GetMaxRound returns the highest round in block_header, -1 if there are no blocks yet.
+	GetMaxRound() (round int64, err error)
+
+	GetImportProgress() (progress ImportProgress, err error)
+	HasBlock(round uint64) (have bool, err error)
+	// GetBlockGaps returns ranges of rounds missing from block_header below the highest round
+	GetBlockGaps() (gaps []BlockGap, err error)
 
 	LoadGenesis(genesis types.Genesis) (err error)
+	// LoadAccounts replaces account, account_asset and asset with accounts as of round, as if accounting had run through round.
+	// txnCounter is the TxnCounter of round's block header, which new asset ids after round are derived from.
+	LoadAccounts(round, txnCounter uint64, accounts []AccountState) (err error)
 
 	GetMetastate(key string) (jsonStrValue string, err error)
 	SetMetastate(key, jsonStrValue string) (err error)
 
 	YieldTxns(ctx context.Context, prevRound int64) <-chan TxnRow
+	// YieldAccounts returns the full state of every account by address, with its asset holdings and the params of assets it created
+	YieldAccounts(ctx context.Context) <-chan AccountStateRow
 
 	CommitRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (err error)
+	// AddRoundAccounting writes account changes for round as part of the current StartBlock()...CommitBlock() transaction.
+	AddRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (err error)
 
 	GetBlock(round uint64) (block types.Block, err error)
+	// GetAccountTotals returns the totals as of round, from the last round at or before it that changed any account
+	GetAccountTotals(round uint64) (totals AccountTotals, err error)
+	// GetDefaultFrozen gets {assetid: default frozen, ...} for all assets
+	GetDefaultFrozen() (defaultFrozen map[uint64]bool, err error)
 
 	TransactionsForAddress(ctx context.Context, addr types.Address, limit, firstRound, lastRound uint64, beforeTime, afterTime time.Time) <-chan TxnRow
-	GetAccounts(ctx context.Context, greaterThan types.Address, limit int) (accounts []models.Account, err error)
+	// GetAccounts pages through accounts by address, closed accounts with no balance are left out unless includeClosed
+	GetAccounts(ctx context.Context, greaterThan types.Address, limit int, includeClosed bool) (accounts []Account, err error)
+	// GetAccountAtRound returns an account's algos and asset holdings as of the end of round, sql.ErrNoRows if it had no history by then
+	GetAccountAtRound(ctx context.Context, addr types.Address, round uint64) (account Account, err error)
+	// GetAssetHolders pages by address through the holders of an asset as of the end of round, AssetHoldersLimit(limit) at a time
+	GetAssetHolders(ctx context.Context, assetid, round uint64, greaterThan types.Address, limit int) (holders []AssetHolder, err error)
+}
+
+// Reaccounter is an IndexerDb that can rebuild account state from txns beside the live account state, which keeps serving meanwhile
+type Reaccounter interface {
+	// StartReaccount makes new empty account state and returns an IndexerDb that does accounting into it, and reads txns and blocks from the live db
+	StartReaccount() (shadow IndexerDb, err error)
+	// FinishReaccount atomically replaces the live account state with the rebuilt one.
+	// It returns ErrReaccountBehind if live accounting has got further than the rebuild, unless force.
+	FinishReaccount(force bool) error
 }
 
+// ErrReaccountBehind is from FinishReaccount
+var ErrReaccountBehind = errors.New("rebuilt account state is behind live accounting")
+
 type dummyFactory struct {
 }
 
@@ -174,10 +390,34 @@ type AssetClose struct {
 }
 
 type RoundUpdates struct {
+	// TxnCounter from the round's block header
+	TxnCounter uint64
+
 	AlgoUpdates   map[[32]byte]int64
 	AcfgUpdates   []AcfgUpdate
 	AssetUpdates  []AssetUpdate
 	FreezeUpdates []FreezeUpdate
 	AssetCloses   []AssetClose
 	AssetDestroys []uint64
+	KeyregUpdates []KeyregUpdate
+	// AlgoCloses are accounts closed by a pay txn CloseRemainderTo
+	AlgoCloses []types.Address
+}
+
+// account_lifecycle events
+const (
+	AccountCreated  = 1
+	AccountClosed   = 2
+	AccountReopened = 3
+)
+
+// KeyregUpdate is the participation part of AccountData as set by a keyreg txn
+type KeyregUpdate struct {
+	Addr            types.Address
+	Status          byte
+	VoteID          types.OneTimeSignatureVerifier
+	SelectionID     types.VRFVerifier
+	VoteFirstValid  types.Round
+	VoteLastValid   types.Round
+	VoteKeyDilution uint64
 }
@@ -27,6 +27,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,7 +35,7 @@ import (
 	"github.com/algorand/go-algorand-sdk/encoding/json"
 	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
 	atypes "github.com/algorand/go-algorand-sdk/types"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/algorand/indexer/types"
 )
@@ -44,7 +45,7 @@ func OpenPostgres(connection string) (idb IndexerDb, err error) {
 	if err != nil {
 		return nil, err
 	}
-	pdb := &postgresIndexerDb{db: db}
+	pdb := &postgresIndexerDb{db: db, connection: connection}
 	err = pdb.init()
 	idb = pdb
 	return
@@ -53,33 +54,78 @@ func OpenPostgres(connection string) (idb IndexerDb, err error) {
 type postgresIndexerDb struct {
 	db *sql.DB
 	tx *sql.Tx
+
+	// to open more connections, see StartReaccount()
+	connection string
+
+	// bulk load mode, see StartBulkLoad()
+	bulk               bool
+	bulkDroppedIndexes bool
+	// COPY into txn_stage for the current tx
+	txnCopy *sql.Stmt
+	// txn_participation rows for the current tx, only one COPY can be running at a time
+	participationRows []participationRow
+	// block_header rows for the current tx, held back while the txn_stage COPY is open
+	pendingHeaders []blockHeaderRow
+
+	// accounting for blocks in the current tx, see AddRoundAccounting()
+	pendingAccounting []roundAccounting
 }
 
-func (db *postgresIndexerDb) init() (err error) {
-	_, err = db.db.Exec(setup_postgres_sql)
-	return
+type roundAccounting struct {
+	updates     RoundUpdates
+	round       uint64
+	rewardsBase uint64
 }
 
-func (db *postgresIndexerDb) AlreadyImported(path string) (imported bool, err error) {
-	row := db.db.QueryRow(`SELECT COUNT(path) FROM imported WHERE path = $1`, path)
-	numpath := 0
-	err = row.Scan(&numpath)
-	return numpath == 1, err
+type blockHeaderRow struct {
+	round        uint64
+	timestamp    int64
+	rewardslevel uint64
+	headerbytes  []byte
 }
 
-func (db *postgresIndexerDb) MarkImported(path string) (err error) {
-	_, err = db.db.Exec(`INSERT INTO imported (path) VALUES ($1)`, path)
-	return err
+type participationRow struct {
+	addr  []byte
+	round uint64
+	intra int
+}
+
+func (db *postgresIndexerDb) init() (err error) {
+	_, err = db.db.Exec(setup_postgres_sql)
+	return
 }
 
 func (db *postgresIndexerDb) StartBlock() (err error) {
+	db.pendingAccounting = db.pendingAccounting[:0]
+	db.pendingHeaders = db.pendingHeaders[:0]
+	db.txnCopy = nil
 	db.tx, err = db.db.BeginTx(context.Background(), nil)
+	if err != nil || !db.bulk {
+		return
+	}
+	// temp tables are per connection, make sure this one has them
+	_, err = db.tx.Exec(`CREATE TEMP TABLE IF NOT EXISTS txn_stage (LIKE txn) ON COMMIT DELETE ROWS;
+CREATE TEMP TABLE IF NOT EXISTS txn_participation_stage (LIKE txn_participation) ON COMMIT DELETE ROWS`)
+	if err != nil {
+		return fmt.Errorf("bulk stage tables, %v", err)
+	}
+	db.txnCopy, err = db.tx.Prepare(pq.CopyIn("txn_stage", "round", "intra", "typeenum", "asset", "txnbytes", "txn"))
 	return
 }
 
 func (db *postgresIndexerDb) AddTransaction(round uint64, intra int, txtypeenum int, assetid uint64, txnbytes []byte, txn types.SignedTxnInBlock, participation [][]byte) error {
-	// TODO: set txn_participation
 	var err error
+	if db.bulk {
+		_, err = db.txnCopy.Exec(round, intra, txtypeenum, assetid, txnbytes, string(json.Encode(txn)))
+		if err != nil {
+			return err
+		}
+		for _, paddr := range participation {
+			db.participationRows = append(db.participationRows, participationRow{addr: paddr, round: round, intra: intra})
+		}
+		return nil
+	}
 	_, err = db.tx.Exec(`INSERT INTO txn (round, intra, typeenum, asset, txnbytes, txn) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`, round, intra, txtypeenum, assetid, txnbytes, string(json.Encode(txn)))
 	if err != nil {
 		return err
@@ -96,17 +142,204 @@ func (db *postgresIndexerDb) AddTransaction(round uint64, intra int, txtypeenum
 	}
 	return err
 }
+
+// finishBulk completes the COPYs of the current tx and merges the stage tables with the same ON CONFLICT DO NOTHING as AddTransaction
+func (db *postgresIndexerDb) finishBulk() (err error) {
+	_, err = db.txnCopy.Exec()
+	if err != nil {
+		return fmt.Errorf("copy txn, %v", err)
+	}
+	err = db.txnCopy.Close()
+	db.txnCopy = nil
+	if err != nil {
+		return fmt.Errorf("copy txn close, %v", err)
+	}
+	pcopy, err := db.tx.Prepare(pq.CopyIn("txn_participation_stage", "addr", "round", "intra"))
+	if err != nil {
+		return fmt.Errorf("copy txn_participation, %v", err)
+	}
+	for _, pr := range db.participationRows {
+		_, err = pcopy.Exec(pr.addr, pr.round, pr.intra)
+		if err != nil {
+			return fmt.Errorf("copy txn_participation, %v", err)
+		}
+	}
+	db.participationRows = db.participationRows[:0]
+	_, err = pcopy.Exec()
+	if err != nil {
+		return fmt.Errorf("copy txn_participation, %v", err)
+	}
+	err = pcopy.Close()
+	if err != nil {
+		return fmt.Errorf("copy txn_participation close, %v", err)
+	}
+	_, err = db.tx.Exec(`INSERT INTO txn SELECT * FROM txn_stage ON CONFLICT DO NOTHING;
+INSERT INTO txn_participation SELECT * FROM txn_participation_stage ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("merge bulk stage, %v", err)
+	}
+	for _, h := range db.pendingHeaders {
+		err = db.insertBlockHeader(h.round, h.timestamp, h.rewardslevel, h.headerbytes)
+		if err != nil {
+			return fmt.Errorf("bulk block header r=%d, %v", h.round, err)
+		}
+	}
+	db.pendingHeaders = db.pendingHeaders[:0]
+	return nil
+}
+
+// AddBlockHeader inserts the header in the current tx, or in bulk mode holds it until the COPY is finished at CommitBlock
+func (db *postgresIndexerDb) AddBlockHeader(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
+	if db.txnCopy != nil {
+		db.pendingHeaders = append(db.pendingHeaders, blockHeaderRow{round: round, timestamp: timestamp, rewardslevel: rewardslevel, headerbytes: headerbytes})
+		return nil
+	}
+	return db.insertBlockHeader(round, timestamp, rewardslevel, headerbytes)
+}
+
+func (db *postgresIndexerDb) insertBlockHeader(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
+	_, err := db.tx.Exec(`INSERT INTO block_header (round, realtime, rewardslevel, header) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, round, time.Unix(timestamp, 0), rewardslevel, headerbytes)
+	return err
+}
+
 func (db *postgresIndexerDb) CommitBlock(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
-	var err error
-	_, err = db.tx.Exec(`INSERT INTO block_header (round, realtime, rewardslevel, header) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, round, time.Unix(timestamp, 0), rewardslevel, headerbytes)
+	err := db.AddBlockHeader(round, timestamp, rewardslevel, headerbytes)
+	if err != nil {
+		return err
+	}
+	if db.txnCopy != nil {
+		// nothing else can run on the connection until the COPY is done
+		err = db.finishBulk()
+		if err != nil {
+			return err
+		}
+	}
+	err = db.commitPendingAccounting()
 	if err != nil {
 		return err
 	}
+	err = db.advanceImportProgress()
+	if err != nil {
+		return fmt.Errorf("import progress, %v", err)
+	}
 	err = db.tx.Commit()
 	db.tx = nil
 	return err
 }
 
+// StartBulkLoad makes AddTransaction stream rows with COPY into temp stage tables that are merged at CommitBlock.
+// If dropIndexes, secondary indexes are dropped until EndBulkLoad.
+// If the process dies before EndBulkLoad the indexes are rebuilt by setup_postgres.sql the next time the db is opened.
+func (db *postgresIndexerDb) StartBulkLoad(dropIndexes bool) (err error) {
+	db.bulk = true
+	if dropIndexes {
+		_, err = db.db.Exec(`DROP INDEX IF EXISTS txn_participation_i; DROP INDEX IF EXISTS block_header_time`)
+		if err != nil {
+			return
+		}
+		db.bulkDroppedIndexes = true
+	}
+	return nil
+}
+
+// EndBulkLoad returns to row at a time inserts and rebuilds any indexes dropped by StartBulkLoad
+func (db *postgresIndexerDb) EndBulkLoad() (err error) {
+	db.bulk = false
+	if db.bulkDroppedIndexes {
+		db.bulkDroppedIndexes = false
+		// CREATE INDEX IF NOT EXISTS ...
+		return db.init()
+	}
+	return nil
+}
+
+// advanceImportProgress moves ImportProgress.ContiguousRound up through any blocks added in this tx
+func (db *postgresIndexerDb) advanceImportProgress() (err error) {
+	progress, err := getImportProgress(db.tx)
+	if err != nil {
+		return
+	}
+	var next bool
+	err = db.tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM block_header WHERE round = $1)`, progress.ContiguousRound+1).Scan(&next)
+	if err != nil || !next {
+		return
+	}
+	// end of the run of blocks starting at ContiguousRound+1
+	err = db.tx.QueryRow(`SELECT min(h.round) FROM block_header h WHERE h.round > $1 AND NOT EXISTS (SELECT 1 FROM block_header n WHERE n.round = h.round + 1)`, progress.ContiguousRound).Scan(&progress.ContiguousRound)
+	if err != nil {
+		return
+	}
+	// never move backwards if another importer got further
+	_, err = db.tx.Exec(`INSERT INTO metastate (k, v) VALUES ('import', $1) ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v WHERE (metastate.v ->> 'contiguous_round')::bigint < (EXCLUDED.v ->> 'contiguous_round')::bigint`, string(json.Encode(progress)))
+	return
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func getImportProgress(q queryRower) (progress ImportProgress, err error) {
+	progress.ContiguousRound = -1
+	var progressJsonStr string
+	err = q.QueryRow(`SELECT v FROM metastate WHERE k = 'import'`).Scan(&progressJsonStr)
+	if err == sql.ErrNoRows {
+		return progress, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Decode([]byte(progressJsonStr), &progress)
+	return
+}
+
+func (db *postgresIndexerDb) GetImportProgress() (progress ImportProgress, err error) {
+	return getImportProgress(db.db)
+}
+
+func (db *postgresIndexerDb) HasBlock(round uint64) (have bool, err error) {
+	err = db.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM block_header WHERE round = $1)`, round).Scan(&have)
+	return
+}
+
+func (db *postgresIndexerDb) GetBlockGaps() (gaps []BlockGap, err error) {
+	rows, err := db.db.Query(`SELECT x.round + 1, x.next_round - 1 FROM (SELECT round, lead(round) OVER (ORDER BY round) AS next_round FROM block_header) x WHERE x.next_round > x.round + 1 ORDER BY x.round`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var minRound sql.NullInt64
+	err = db.db.QueryRow(`SELECT min(round) FROM block_header`).Scan(&minRound)
+	if err != nil {
+		return
+	}
+	if minRound.Valid && minRound.Int64 > 0 {
+		gaps = append(gaps, BlockGap{First: 0, Last: uint64(minRound.Int64 - 1)})
+	}
+	for rows.Next() {
+		var gap BlockGap
+		err = rows.Scan(&gap.First, &gap.Last)
+		if err != nil {
+			return
+		}
+		gaps = append(gaps, gap)
+	}
+	err = rows.Err()
+	return
+}
+
+func (db *postgresIndexerDb) GetMaxRound() (round int64, err error) {
+	row := db.db.QueryRow(`SELECT max(round) FROM block_header`)
+	var maxRound sql.NullInt64
+	err = row.Scan(&maxRound)
+	if err != nil {
+		return
+	}
+	if !maxRound.Valid {
+		return -1, nil
+	}
+	return maxRound.Int64, nil
+}
+
 func (db *postgresIndexerDb) GetBlockHeader(round uint64) (block types.Block, err error) {
 	row := db.db.QueryRow(`SELECT header FROM block_header WHERE round = $1`, round)
 	var blockbytes []byte
@@ -132,10 +365,12 @@ func (db *postgresIndexerDb) GetAsset(assetid uint64) (asset types.AssetParams,
 
 // GetDefaultFrozen get {assetid:default frozen, ...} for all assets
 func (db *postgresIndexerDb) GetDefaultFrozen() (defaultFrozen map[uint64]bool, err error) {
-	rows, err := db.db.Query(`SELECT index, params -> 'df' FROM asset`)
+	// 'df' is omitted when false
+	rows, err := db.db.Query(`SELECT index, COALESCE((params ->> 'df')::boolean, false) FROM asset`)
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 	defaultFrozen = make(map[uint64]bool)
 	for rows.Next() {
 		var assetid uint64
@@ -146,9 +381,74 @@ func (db *postgresIndexerDb) GetDefaultFrozen() (defaultFrozen map[uint64]bool,
 		}
 		defaultFrozen[assetid] = frozen
 	}
+	err = rows.Err()
 	return
 }
 
+// accountLoader writes whole accounts including their assets, for LoadGenesis and LoadAccounts
+type accountLoader struct {
+	// round accounts are created at, NULL if unknown
+	createdAt sql.NullInt64
+
+	setAccount *sql.Stmt
+	setAsset   *sql.Stmt
+	setHolding *sql.Stmt
+}
+
+func prepareAccountLoader(tx *sql.Tx) (al *accountLoader, err error) {
+	al = &accountLoader{}
+	al.setAccount, err = tx.Prepare(`INSERT INTO account (addr, microalgos, rewardsbase, account_data, created_at) VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		return
+	}
+	al.setAsset, err = tx.Prepare(`INSERT INTO asset (index, creator_addr, params) VALUES ($1, $2, $3)`)
+	if err != nil {
+		al.Close()
+		return
+	}
+	al.setHolding, err = tx.Prepare(`INSERT INTO account_asset (addr, assetid, amount, frozen) VALUES ($1, $2, $3, $4)`)
+	if err != nil {
+		al.Close()
+		return
+	}
+	return
+}
+
+func (al *accountLoader) Close() {
+	for _, stmt := range []*sql.Stmt{al.setAccount, al.setAsset, al.setHolding} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (al *accountLoader) load(addr types.Address, ad types.AccountData) (err error) {
+	for assetid, params := range ad.AssetParams {
+		_, err = al.setAsset.Exec(uint64(assetid), addr[:], string(json.Encode(params)))
+		if err != nil {
+			return fmt.Errorf("error setting asset %d, %v", assetid, err)
+		}
+	}
+	for assetid, holding := range ad.Assets {
+		_, err = al.setHolding.Exec(addr[:], uint64(assetid), holding.Amount, holding.Frozen)
+		if err != nil {
+			return fmt.Errorf("error setting %s asset %d, %v", atypes.Address(addr).String(), assetid, err)
+		}
+	}
+	microalgos := ad.MicroAlgos
+	rewardsbase := ad.RewardsBase
+	// account_data holds what isn't in other columns and tables
+	ad.MicroAlgos = 0
+	ad.RewardsBase = 0
+	ad.AssetParams = nil
+	ad.Assets = nil
+	_, err = al.setAccount.Exec(addr[:], microalgos, rewardsbase, string(json.Encode(ad)), al.createdAt)
+	if err != nil {
+		return fmt.Errorf("error setting account %s, %v", atypes.Address(addr).String(), err)
+	}
+	return nil
+}
+
 func (db *postgresIndexerDb) LoadGenesis(genesis types.Genesis) (err error) {
 	tx, err := db.db.Begin()
 	if err != nil {
@@ -156,23 +456,37 @@ func (db *postgresIndexerDb) LoadGenesis(genesis types.Genesis) (err error) {
 	}
 	defer tx.Rollback() // ignored if .Commit() first
 
-	setAccount, err := tx.Prepare(`INSERT INTO account (addr, microalgos, rewardsbase, account_data) VALUES ($1, $2, 0, $3)`)
+	al, err := prepareAccountLoader(tx)
 	if err != nil {
 		return
 	}
-	defer setAccount.Close()
+	defer al.Close()
+	al.createdAt = sql.NullInt64{Int64: 0, Valid: true}
 
 	total := uint64(0)
 	for ai, alloc := range genesis.Allocation {
 		addr, err := atypes.DecodeAddress(alloc.Address)
-		if len(alloc.State.AssetParams) > 0 || len(alloc.State.Assets) > 0 {
-			return fmt.Errorf("genesis account[%d] has unhandled asset", ai)
+		if err != nil {
+			return fmt.Errorf("genesis account[%d] bad address %#v, %v", ai, alloc.Address, err)
 		}
-		_, err = setAccount.Exec(addr[:], alloc.State.MicroAlgos, string(json.Encode(alloc.State)))
-		total += uint64(alloc.State.MicroAlgos)
+		err = al.load(types.Address(addr), alloc.State)
 		if err != nil {
-			return fmt.Errorf("error setting genesis account[%d], %v", ai, err)
+			return fmt.Errorf("genesis account[%d], %v", ai, err)
 		}
+		total += uint64(alloc.State.MicroAlgos)
+	}
+	err = snapshotHistory(tx, 0)
+	if err != nil {
+		return
+	}
+	totals, err := statusTotals(tx, nil)
+	if err != nil {
+		return
+	}
+	// accounting starts with round 0
+	err = setImportState(tx, ImportState{AccountRound: -1, Totals: &totals})
+	if err != nil {
+		return
 	}
 	err = tx.Commit()
 	fmt.Printf("genesis %d accounts %d microalgos, %v\n", len(genesis.Allocation), total, err)
@@ -180,6 +494,54 @@ func (db *postgresIndexerDb) LoadGenesis(genesis types.Genesis) (err error) {
 
 }
 
+// LoadAccounts is part of idb.IndexerDb
+func (db *postgresIndexerDb) LoadAccounts(round, txnCounter uint64, accounts []AccountState) (err error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback() // ignored if .Commit() first
+
+	istate, err := lockAccounting(tx)
+	if err != nil {
+		return
+	}
+	// history and totals from before round would not lead up to the loaded state, and any after it are from the replaced state
+	_, err = tx.Exec(`TRUNCATE account, account_asset, asset, account_history, account_asset_history, account_totals, account_lifecycle`)
+	if err != nil {
+		return fmt.Errorf("clearing account state, %v", err)
+	}
+	al, err := prepareAccountLoader(tx)
+	if err != nil {
+		return
+	}
+	defer al.Close()
+	for _, acct := range accounts {
+		err = al.load(acct.Address, acct.Data)
+		if err != nil {
+			return
+		}
+	}
+	err = snapshotHistory(tx, round)
+	if err != nil {
+		return
+	}
+
+	totals, err := statusTotals(tx, nil)
+	if err != nil {
+		return
+	}
+	totals.Round = round
+	istate.Totals = &totals
+	istate.AccountRound = int64(round)
+	istate.TxnCounter = txnCounter
+	err = setImportState(tx, istate)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
 func (db *postgresIndexerDb) GetMetastate(key string) (jsonStrValue string, err error) {
 	row := db.db.QueryRow(`SELECT v FROM metastate WHERE k = $1`, key)
 	err = row.Scan(&jsonStrValue)
@@ -232,26 +594,356 @@ func (db *postgresIndexerDb) YieldTxns(ctx context.Context, prevRound int64) <-c
 	return results
 }
 
+type holdingJson struct {
+	AssetId uint64 `codec:"i"`
+	Amount  uint64 `codec:"a"`
+	Frozen  bool   `codec:"f"`
+}
+
+type assetParamsJson struct {
+	AssetId uint64            `codec:"i"`
+	Params  types.AssetParams `codec:"p"`
+}
+
+func (db *postgresIndexerDb) yieldAccountsThread(ctx context.Context, rows *sql.Rows, results chan<- AccountStateRow) {
+	defer close(results)
+	defer rows.Close()
+	for rows.Next() {
+		var row AccountStateRow
+		var addr []byte
+		var microalgos uint64
+		var dataJsonStr, holdingsJsonStr, paramsJsonStr *string
+		err := rows.Scan(&addr, &microalgos, &row.Data.RewardsBase, &dataJsonStr, &row.Deleted, &holdingsJsonStr, &paramsJsonStr)
+		if err == nil && len(addr) != len(row.Address) {
+			err = errors.New("loaded invalid addr from db in YieldAccounts")
+		}
+		if err == nil {
+			copy(row.Address[:], addr)
+			err = decodeAccountState(&row.AccountState, microalgos, dataJsonStr, holdingsJsonStr, paramsJsonStr)
+		}
+		row.Error = err
+		select {
+		case <-ctx.Done():
+			return
+		case results <- row:
+			if err != nil {
+				return
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		select {
+		case <-ctx.Done():
+		case results <- AccountStateRow{Error: err}:
+		}
+	}
+}
+
+// decodeAccountState puts the columns and json of YieldAccounts back together into an AccountData
+func decodeAccountState(acct *AccountState, microalgos uint64, dataJsonStr, holdingsJsonStr, paramsJsonStr *string) (err error) {
+	rewardsBase := acct.Data.RewardsBase
+	if dataJsonStr != nil {
+		err = json.Decode([]byte(*dataJsonStr), &acct.Data)
+		if err != nil {
+			return fmt.Errorf("bad account_data for %s, %v", atypes.Address(acct.Address).String(), err)
+		}
+	}
+	acct.Data.MicroAlgos = types.MicroAlgos(microalgos)
+	acct.Data.RewardsBase = rewardsBase
+	if holdingsJsonStr != nil {
+		var holdings []holdingJson
+		err = json.Decode([]byte(*holdingsJsonStr), &holdings)
+		if err != nil {
+			return fmt.Errorf("bad holdings for %s, %v", atypes.Address(acct.Address).String(), err)
+		}
+		acct.Data.Assets = make(map[types.AssetIndex]types.AssetHolding, len(holdings))
+		for _, h := range holdings {
+			acct.Data.Assets[types.AssetIndex(h.AssetId)] = types.AssetHolding{Amount: h.Amount, Frozen: h.Frozen}
+		}
+	}
+	if paramsJsonStr != nil {
+		var params []assetParamsJson
+		err = json.Decode([]byte(*paramsJsonStr), &params)
+		if err != nil {
+			return fmt.Errorf("bad asset params for %s, %v", atypes.Address(acct.Address).String(), err)
+		}
+		acct.Data.AssetParams = make(map[types.AssetIndex]types.AssetParams, len(params))
+		for _, p := range params {
+			acct.Data.AssetParams[types.AssetIndex(p.AssetId)] = p.Params
+		}
+	}
+	return nil
+}
+
+// YieldAccounts is part of idb.IndexerDb
+func (db *postgresIndexerDb) YieldAccounts(ctx context.Context) <-chan AccountStateRow {
+	results := make(chan AccountStateRow, 1)
+	rows, err := db.db.QueryContext(ctx, `SELECT a.addr, a.microalgos, a.rewardsbase, a.account_data, a.deleted,
+ (SELECT json_agg(json_build_object('i', aa.assetid, 'a', aa.amount, 'f', aa.frozen)) FROM account_asset aa WHERE aa.addr = a.addr),
+ (SELECT json_agg(json_build_object('i', x.index, 'p', x.params)) FROM asset x WHERE x.creator_addr = a.addr)
+FROM account a ORDER BY a.addr`)
+	if err != nil {
+		results <- AccountStateRow{Error: err}
+		close(results)
+		return results
+	}
+	go db.yieldAccountsThread(ctx, rows, results)
+	return results
+}
+
 func (db *postgresIndexerDb) CommitRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (err error) {
-	any := false
 	tx, err := db.db.Begin()
 	if err != nil {
 		return
 	}
-	defer tx.Rollback() // ignored if .Commit() first
+	return commitRoundTx(pgAccountingTx{tx}, updates, round, rewardsBase)
+}
+
+// accountingTx is the transaction account changes are written in, pgAccountingTx for postgres
+type accountingTx interface {
+	// lockAccounting waits for any other accounting writer, holds the lock until the tx ends and reads metastate "state"
+	lockAccounting() (istate ImportState, err error)
+	statusTotals(addrs [][]byte) (totals AccountTotals, err error)
+	applyRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (any bool, err error)
+	recordHistory(updates RoundUpdates, round uint64, addrs [][]byte) error
+	setImportState(istate ImportState) error
+	Commit() error
+	Rollback() error
+}
+
+type pgAccountingTx struct {
+	*sql.Tx
+}
+
+func (at pgAccountingTx) lockAccounting() (ImportState, error) {
+	return lockAccounting(at.Tx)
+}
+func (at pgAccountingTx) statusTotals(addrs [][]byte) (AccountTotals, error) {
+	return statusTotals(at.Tx, addrs)
+}
+func (at pgAccountingTx) applyRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (bool, error) {
+	return applyRoundAccounting(at.Tx, updates, round, rewardsBase)
+}
+func (at pgAccountingTx) recordHistory(updates RoundUpdates, round uint64, addrs [][]byte) error {
+	return recordHistory(at.Tx, updates, round, addrs)
+}
+func (at pgAccountingTx) setImportState(istate ImportState) error {
+	return setImportState(at.Tx, istate)
+}
+
+// commitRoundTx is CommitRoundAccounting in at, which is committed if the round changed anything and rolled back otherwise
+func commitRoundTx(at accountingTx, updates RoundUpdates, round, rewardsBase uint64) (err error) {
+	defer at.Rollback() // ignored if .Commit() first
+
+	istate, err := at.lockAccounting()
+	if err != nil {
+		return
+	}
+	any, err := commitRoundAccounting(at, &istate, updates, round, rewardsBase)
+	if err != nil || !any {
+		return
+	}
+	return at.Commit()
+}
+
+// AddRoundAccounting is part of idb.IndexerDb
+// The updates are applied at CommitBlock, after any bulk COPY is done with the connection.
+func (db *postgresIndexerDb) AddRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) error {
+	db.pendingAccounting = append(db.pendingAccounting, roundAccounting{updates: updates, round: round, rewardsBase: rewardsBase})
+	return nil
+}
+
+func (db *postgresIndexerDb) commitPendingAccounting() (err error) {
+	if len(db.pendingAccounting) == 0 {
+		return nil
+	}
+	at := pgAccountingTx{db.tx}
+	istate, err := at.lockAccounting()
+	if err != nil {
+		return
+	}
+	for _, ra := range db.pendingAccounting {
+		_, err = commitRoundAccounting(at, &istate, ra.updates, ra.round, ra.rewardsBase)
+		if err != nil {
+			return fmt.Errorf("round %d accounting, %v", ra.round, err)
+		}
+	}
+	db.pendingAccounting = db.pendingAccounting[:0]
+	return nil
+}
+
+// commitRoundAccounting applies a round's account changes, checks that they conserve money and records the new state and totals.
+// istate is kept up to date. any is false if there was nothing to do.
+func commitRoundAccounting(at accountingTx, istate *ImportState, updates RoundUpdates, round, rewardsBase uint64) (any bool, err error) {
+	// before anything is written, the deltas are additive
+	err = checkAccountRound(*istate, round)
+	if err != nil {
+		return
+	}
+	touched := touchedAddrs(updates)
+	before, err := at.statusTotals(touched)
+	if err != nil {
+		return
+	}
+	any, err = at.applyRoundAccounting(updates, round, rewardsBase)
+	if err != nil || !any {
+		return
+	}
+	err = at.recordHistory(updates, round, touched)
+	if err != nil {
+		return
+	}
+	after, err := at.statusTotals(touched)
+	if err != nil {
+		return
+	}
+	if after.Money() != before.Money() {
+		return false, fmt.Errorf("round %d does not conserve money, the accounts it changed had %d before and %d after", round, before.Money(), after.Money())
+	}
+	if istate.Totals == nil {
+		// first round accounted since totals were added, count everything
+		var totals AccountTotals
+		totals, err = at.statusTotals(nil)
+		if err != nil {
+			return
+		}
+		istate.Totals = &totals
+	} else {
+		istate.Totals.Add(after)
+		istate.Totals.Sub(before)
+	}
+	istate.Totals.Round = round
+	istate.Totals.RewardsLevel = rewardsBase
+	istate.AccountRound = int64(round)
+	istate.TxnCounter = updates.TxnCounter
+	err = at.setImportState(*istate)
+	return
+}
 
+// touchedAddrs is every account whose algos or status a round changes
+func touchedAddrs(updates RoundUpdates) [][]byte {
+	addrs := make([][]byte, 0, len(updates.AlgoUpdates)+len(updates.KeyregUpdates))
+	for addr := range updates.AlgoUpdates {
+		a := addr
+		addrs = append(addrs, a[:])
+	}
+	for _, ku := range updates.KeyregUpdates {
+		if _, ok := updates.AlgoUpdates[ku.Addr]; !ok {
+			a := ku.Addr
+			addrs = append(addrs, a[:])
+		}
+	}
+	return addrs
+}
+
+type holdingKey struct {
+	addr    types.Address
+	assetid uint64
+}
+
+// recordHistory adds the state of the accounts and asset holdings a round changed to account_history and account_asset_history
+func recordHistory(tx *sql.Tx, updates RoundUpdates, round uint64, addrs [][]byte) (err error) {
+	if len(addrs) > 0 {
+		_, err = tx.Exec(`INSERT INTO account_history (addr, round, microalgos, rewardsbase, account_data, deleted) SELECT addr, $2, microalgos, rewardsbase, account_data, deleted FROM account WHERE addr = ANY($1)
+ON CONFLICT (addr, round) DO UPDATE SET microalgos = EXCLUDED.microalgos, rewardsbase = EXCLUDED.rewardsbase, account_data = EXCLUDED.account_data, deleted = EXCLUDED.deleted`, pq.ByteaArray(addrs), round)
+		if err != nil {
+			return fmt.Errorf("account history, %v", err)
+		}
+	}
+	seen := make(map[holdingKey]bool)
+	var holdingAddrs [][]byte
+	var holdingIds []int64
+	addHolding := func(addr types.Address, assetid uint64) {
+		hk := holdingKey{addr, assetid}
+		if seen[hk] {
+			return
+		}
+		seen[hk] = true
+		holdingAddrs = append(holdingAddrs, addr[:])
+		holdingIds = append(holdingIds, int64(assetid))
+	}
+	for _, au := range updates.AssetUpdates {
+		addHolding(au.Addr, au.AssetId)
+	}
+	for _, fu := range updates.FreezeUpdates {
+		addHolding(fu.Addr, fu.AssetId)
+	}
+	for _, ac := range updates.AssetCloses {
+		addHolding(ac.Sender, ac.AssetId)
+		addHolding(ac.CloseTo, ac.AssetId)
+	}
+	if len(holdingAddrs) == 0 {
+		return nil
+	}
+	// a holding that is no longer in account_asset was closed or destroyed
+	_, err = tx.Exec(`INSERT INTO account_asset_history (addr, assetid, round, amount, frozen, deleted)
+SELECT h.addr, h.assetid, $3, COALESCE(aa.amount, 0), COALESCE(aa.frozen, false), aa.addr IS NULL FROM unnest($1::bytea[], $2::bigint[]) AS h (addr, assetid) LEFT JOIN account_asset aa ON aa.addr = h.addr AND aa.assetid = h.assetid
+ON CONFLICT (addr, assetid, round) DO UPDATE SET amount = EXCLUDED.amount, frozen = EXCLUDED.frozen, deleted = EXCLUDED.deleted`, pq.ByteaArray(holdingAddrs), pq.Int64Array(holdingIds), round)
+	if err != nil {
+		return fmt.Errorf("account asset history, %v", err)
+	}
+	return nil
+}
+
+// snapshotHistory records every account and asset holding as of round, after they were loaded wholesale
+func snapshotHistory(tx *sql.Tx, round uint64) (err error) {
+	_, err = tx.Exec(`INSERT INTO account_history (addr, round, microalgos, rewardsbase, account_data, deleted) SELECT addr, $1, microalgos, rewardsbase, account_data, deleted FROM account
+ON CONFLICT (addr, round) DO UPDATE SET microalgos = EXCLUDED.microalgos, rewardsbase = EXCLUDED.rewardsbase, account_data = EXCLUDED.account_data, deleted = EXCLUDED.deleted`, round)
+	if err != nil {
+		return fmt.Errorf("account history, %v", err)
+	}
+	_, err = tx.Exec(`INSERT INTO account_asset_history (addr, assetid, round, amount, frozen, deleted) SELECT addr, assetid, $1, amount, frozen, false FROM account_asset
+ON CONFLICT (addr, assetid, round) DO UPDATE SET amount = EXCLUDED.amount, frozen = EXCLUDED.frozen, deleted = EXCLUDED.deleted`, round)
+	if err != nil {
+		return fmt.Errorf("account asset history, %v", err)
+	}
+	return nil
+}
+
+// statusTotals adds up account.microalgos and reward units by status for addrs, or for all accounts if addrs is nil
+func statusTotals(tx *sql.Tx, addrs [][]byte) (totals AccountTotals, err error) {
+	query := `SELECT COALESCE((account_data ->> 'onl')::int, 0), COALESCE(sum(microalgos), 0), COALESCE(sum(microalgos / ` + strconv.Itoa(types.RewardUnit) + `), 0) FROM account`
+	var rows *sql.Rows
+	if addrs == nil {
+		rows, err = tx.Query(query + ` GROUP BY 1`)
+	} else if len(addrs) == 0 {
+		return
+	} else {
+		rows, err = tx.Query(query+` WHERE addr = ANY($1) GROUP BY 1`, pq.ByteaArray(addrs))
+	}
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status int
+		var money, units uint64
+		err = rows.Scan(&status, &money, &units)
+		if err != nil {
+			return
+		}
+		ac := totals.Class(byte(status))
+		ac.Money += money
+		ac.RewardUnits += units
+	}
+	err = rows.Err()
+	return
+}
+
+// applyRoundAccounting writes account changes, any is false if there were none
+func applyRoundAccounting(tx *sql.Tx, updates RoundUpdates, round, rewardsBase uint64) (any bool, err error) {
 	if len(updates.AlgoUpdates) > 0 {
 		any = true
 		// account_data json is only used on account creation, otherwise the account data jsonb field is updated from the delta
-		setalgo, err := tx.Prepare(`INSERT INTO account (addr, microalgos, rewardsbase) VALUES ($1, $2, $3) ON CONFLICT (addr) DO UPDATE SET microalgos = account.microalgos + EXCLUDED.microalgos, rewardsbase = EXCLUDED.rewardsbase`)
+		setalgo, err := tx.Prepare(`INSERT INTO account (addr, microalgos, rewardsbase, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT (addr) DO UPDATE SET microalgos = account.microalgos + EXCLUDED.microalgos, rewardsbase = EXCLUDED.rewardsbase`)
 		if err != nil {
-			return fmt.Errorf("prepare update algo, %v", err)
+			return false, fmt.Errorf("prepare update algo, %v", err)
 		}
 		defer setalgo.Close()
 		for addr, delta := range updates.AlgoUpdates {
-			_, err = setalgo.Exec(addr[:], delta, rewardsBase)
+			_, err = setalgo.Exec(addr[:], delta, rewardsBase, round)
 			if err != nil {
-				return fmt.Errorf("update algo, %v", err)
+				return false, fmt.Errorf("update algo, %v", err)
 			}
 		}
 	}
@@ -259,13 +951,13 @@ func (db *postgresIndexerDb) CommitRoundAccounting(updates RoundUpdates, round,
 		any = true
 		setacfg, err := tx.Prepare(`INSERT INTO asset (index, creator_addr, params) VALUES ($1, $2, $3) ON CONFLICT (index) DO UPDATE SET params = EXCLUDED.params`)
 		if err != nil {
-			return fmt.Errorf("prepare set asset, %v", err)
+			return false, fmt.Errorf("prepare set asset, %v", err)
 		}
 		defer setacfg.Close()
 		for _, au := range updates.AcfgUpdates {
 			_, err = setacfg.Exec(au.AssetId, au.Creator[:], string(json.Encode(au.Params)))
 			if err != nil {
-				return fmt.Errorf("update asset, %v", err)
+				return false, fmt.Errorf("update asset, %v", err)
 			}
 		}
 	}
@@ -273,13 +965,13 @@ func (db *postgresIndexerDb) CommitRoundAccounting(updates RoundUpdates, round,
 		any = true
 		seta, err := tx.Prepare(`INSERT INTO account_asset (addr, assetid, amount, frozen) VALUES ($1, $2, $3, $4) ON CONFLICT (addr, assetid) DO UPDATE SET amount = account_asset.amount + EXCLUDED.amount`)
 		if err != nil {
-			return fmt.Errorf("prepare set account_asset, %v", err)
+			return false, fmt.Errorf("prepare set account_asset, %v", err)
 		}
 		defer seta.Close()
 		for _, au := range updates.AssetUpdates {
 			_, err = seta.Exec(au.Addr[:], au.AssetId, au.Delta, au.DefaultFrozen)
 			if err != nil {
-				return fmt.Errorf("update account asset, %v", err)
+				return false, fmt.Errorf("update account asset, %v", err)
 			}
 		}
 	}
@@ -287,80 +979,189 @@ func (db *postgresIndexerDb) CommitRoundAccounting(updates RoundUpdates, round,
 		any = true
 		fr, err := tx.Prepare(`INSERT INTO account_asset (addr, assetid, amount, frozen) VALUES ($1, $2, 0, $3) ON CONFLICT (addr, assetid) DO UPDATE SET frozen = EXCLUDED.frozen`)
 		if err != nil {
-			return fmt.Errorf("prepare asset freeze, %v", err)
+			return false, fmt.Errorf("prepare asset freeze, %v", err)
 		}
 		defer fr.Close()
 		for _, fs := range updates.FreezeUpdates {
 			_, err = fr.Exec(fs.Addr[:], fs.AssetId, fs.Frozen)
 			if err != nil {
-				return fmt.Errorf("update asset freeze, %v", err)
+				return false, fmt.Errorf("update asset freeze, %v", err)
 			}
 		}
 	}
 	if len(updates.AssetCloses) > 0 {
 		any = true
 		acs, err := tx.Prepare(`INSERT INTO account_asset (addr, assetid, amount)
-SELECT $1, $2, x.amount FROM account_asset x WHERE x.addr = $3
+SELECT $1, $2, x.amount FROM account_asset x WHERE x.addr = $3 AND x.assetid = $2
 ON CONFLICT (addr, assetid) DO UPDATE SET amount = account_asset.amount + EXCLUDED.amount`)
 		if err != nil {
-			return fmt.Errorf("prepare asset close1, %v", err)
+			return false, fmt.Errorf("prepare asset close1, %v", err)
 		}
 		defer acs.Close()
-		acd, err := tx.Prepare(`DELETE FROM account_asset WHERE addr = $1`)
+		acd, err := tx.Prepare(`DELETE FROM account_asset WHERE addr = $1 AND assetid = $2`)
 		if err != nil {
-			return fmt.Errorf("prepare asset close2, %v", err)
+			return false, fmt.Errorf("prepare asset close2, %v", err)
 		}
 		defer acd.Close()
 		for _, ac := range updates.AssetCloses {
 			_, err = acs.Exec(ac.CloseTo[:], ac.AssetId, ac.Sender[:])
 			if err != nil {
-				return fmt.Errorf("asset close send, %v", err)
+				return false, fmt.Errorf("asset close send, %v", err)
 			}
-			_, err = acd.Exec(ac.Sender[:])
+			_, err = acd.Exec(ac.Sender[:], ac.AssetId)
 			if err != nil {
-				return fmt.Errorf("asset close del, %v", err)
+				return false, fmt.Errorf("asset close del, %v", err)
 			}
 		}
 	}
 	if len(updates.AssetDestroys) > 0 {
 		any = true
+		// the holdings are gone afterwards, so record their end in history now
+		adh, err := tx.Prepare(`INSERT INTO account_asset_history (addr, assetid, round, amount, frozen, deleted) SELECT addr, assetid, $2, 0, frozen, true FROM account_asset WHERE assetid = $1
+ON CONFLICT (addr, assetid, round) DO UPDATE SET amount = 0, deleted = true`)
+		if err != nil {
+			return false, fmt.Errorf("prepare asset destroy history, %v", err)
+		}
+		defer adh.Close()
 		// Note! leaves `asset` row present for historical reference, but deletes all holdings from all accounts
 		ads, err := tx.Prepare(`DELETE FROM account_asset WHERE assetid = $1`)
 		if err != nil {
-			return fmt.Errorf("prepare asset destroy, %v", err)
+			return false, fmt.Errorf("prepare asset destroy, %v", err)
 		}
 		defer ads.Close()
 		for _, assetId := range updates.AssetDestroys {
-			ads.Exec(assetId)
+			_, err = adh.Exec(assetId, round)
+			if err != nil {
+				return false, fmt.Errorf("asset destroy history, %v", err)
+			}
+			_, err = ads.Exec(assetId)
+			if err != nil {
+				return false, fmt.Errorf("asset destroy, %v", err)
+			}
+		}
+	}
+	if len(updates.KeyregUpdates) > 0 {
+		any = true
+		// replace all the participation fields, zero values are omitted from the json
+		kr, err := tx.Prepare(`UPDATE account SET account_data = (COALESCE(account_data, '{}'::jsonb) - 'onl' - 'vote' - 'sel' - 'voteFst' - 'voteLst' - 'voteKD') || $2::jsonb WHERE addr = $1`)
+		if err != nil {
+			return false, fmt.Errorf("prepare keyreg, %v", err)
+		}
+		defer kr.Close()
+		for _, ku := range updates.KeyregUpdates {
+			ad := types.AccountData{
+				Status:          ku.Status,
+				VoteID:          ku.VoteID,
+				SelectionID:     ku.SelectionID,
+				VoteFirstValid:  ku.VoteFirstValid,
+				VoteLastValid:   ku.VoteLastValid,
+				VoteKeyDilution: ku.VoteKeyDilution,
+			}
+			_, err = kr.Exec(ku.Addr[:], string(json.Encode(ad)))
 			if err != nil {
-				return fmt.Errorf("asset destroy, %v", err)
+				return false, fmt.Errorf("keyreg, %v", err)
 			}
 		}
 	}
-	if !any {
+	if len(updates.AlgoUpdates) > 0 {
+		err = updateAccountLifecycle(tx, updates, round)
+		if err != nil {
+			return false, fmt.Errorf("account lifecycle, %v", err)
+		}
+	}
+	return
+}
+
+// updateAccountLifecycle records accounts created, closed and reopened by a round, after its algo updates are applied
+func updateAccountLifecycle(tx *sql.Tx, updates RoundUpdates, round uint64) (err error) {
+	touched := make([][]byte, 0, len(updates.AlgoUpdates))
+	for addr := range updates.AlgoUpdates {
+		a := addr
+		touched = append(touched, a[:])
+	}
+	_, err = tx.Exec(`INSERT INTO account_lifecycle (addr, round, event) SELECT addr, $2, $3 FROM account WHERE addr = ANY($1) AND created_at = $2 ON CONFLICT DO NOTHING`, pq.ByteaArray(touched), round, AccountCreated)
+	if err != nil {
 		return
 	}
-	var istate ImportState
-	staterow := tx.QueryRow(`SELECT v FROM metastate WHERE k = 'state'`)
+	// a closed account can only be touched again by receiving algos
+	_, err = tx.Exec(`WITH reopened AS (UPDATE account SET deleted = false WHERE addr = ANY($1) AND deleted RETURNING addr)
+INSERT INTO account_lifecycle (addr, round, event) SELECT addr, $2, $3 FROM reopened ON CONFLICT DO NOTHING`, pq.ByteaArray(touched), round, AccountReopened)
+	if err != nil {
+		return
+	}
+	if len(updates.AlgoCloses) == 0 {
+		return
+	}
+	closed := make([][]byte, len(updates.AlgoCloses))
+	for i, addr := range updates.AlgoCloses {
+		a := addr
+		closed[i] = a[:]
+	}
+	// closing resets participation like go-algorand clearing the account record
+	_, err = tx.Exec(`UPDATE account SET closed_at = $2, deleted = (microalgos = 0), account_data = COALESCE(account_data, '{}'::jsonb) - 'onl' - 'vote' - 'sel' - 'voteFst' - 'voteLst' - 'voteKD' WHERE addr = ANY($1)`, pq.ByteaArray(closed), round)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO account_lifecycle (addr, round, event) SELECT addr, $2, $3 FROM account WHERE addr = ANY($1) ON CONFLICT DO NOTHING`, pq.ByteaArray(closed), round, AccountClosed)
+	if err != nil {
+		return
+	}
+	// paid again after closing in the same round
+	_, err = tx.Exec(`INSERT INTO account_lifecycle (addr, round, event) SELECT addr, $2, $3 FROM account WHERE addr = ANY($1) AND NOT deleted ON CONFLICT DO NOTHING`, pq.ByteaArray(closed), round, AccountReopened)
+	return
+}
+
+// accountingLockKey is the advisory lock taken by every transaction that writes account state
+const accountingLockKey = 0x69647861636374 // "idxacct"
+
+// lockAccounting waits for any other accounting writer to finish and then reads metastate "state".
+// AccountRound is -1 if no accounting has been done.
+// The lock is held until tx ends, so the state can't change underneath the caller.
+func lockAccounting(tx *sql.Tx) (istate ImportState, err error) {
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, accountingLockKey)
+	if err != nil {
+		return istate, fmt.Errorf("accounting lock, %v", err)
+	}
+	istate.AccountRound = -1
 	var stateJsonStr string
-	err = staterow.Scan(&stateJsonStr)
+	err = tx.QueryRow(`SELECT v FROM metastate WHERE k = 'state'`).Scan(&stateJsonStr)
 	if err == sql.ErrNoRows {
-		// ok
-	} else if err != nil {
+		return istate, nil
+	}
+	if err != nil {
 		return
-	} else {
-		err = json.Decode([]byte(stateJsonStr), &istate)
-		if err != nil {
-			return
-		}
 	}
-	istate.AccountRound = int64(round)
+	err = json.Decode([]byte(stateJsonStr), &istate)
+	return
+}
+
+// checkAccountRound refuses to apply a round's deltas twice
+func checkAccountRound(istate ImportState, round uint64) error {
+	if int64(round) <= istate.AccountRound {
+		return fmt.Errorf("round %d already accounted for, accounting is through round %d", round, istate.AccountRound)
+	}
+	return nil
+}
+
+// setImportState writes metastate "state" and, if it has totals, adds them to the account_totals history
+func setImportState(tx *sql.Tx, istate ImportState) (err error) {
 	sjs := string(json.Encode(istate))
 	_, err = tx.Exec(`INSERT INTO metastate (k, v) VALUES ('state', $1) ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v`, sjs)
-	if err != nil {
+	if err != nil || istate.Totals == nil {
 		return
 	}
-	return tx.Commit()
+	t := istate.Totals
+	_, err = tx.Exec(`INSERT INTO account_totals (round, online, onlinerewardunits, offline, offlinerewardunits, notparticipating, notparticipatingrewardunits, rewardslevel) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (round) DO UPDATE SET online = EXCLUDED.online, onlinerewardunits = EXCLUDED.onlinerewardunits, offline = EXCLUDED.offline, offlinerewardunits = EXCLUDED.offlinerewardunits, notparticipating = EXCLUDED.notparticipating, notparticipatingrewardunits = EXCLUDED.notparticipatingrewardunits, rewardslevel = EXCLUDED.rewardslevel`,
+		t.Round, t.Online.Money, t.Online.RewardUnits, t.Offline.Money, t.Offline.RewardUnits, t.NotParticipating.Money, t.NotParticipating.RewardUnits, t.RewardsLevel)
+	return
+}
+
+// GetAccountTotals is part of idb.IndexerDb
+func (db *postgresIndexerDb) GetAccountTotals(round uint64) (t AccountTotals, err error) {
+	err = db.db.QueryRow(`SELECT round, online, onlinerewardunits, offline, offlinerewardunits, notparticipating, notparticipatingrewardunits, rewardslevel FROM account_totals WHERE round <= $1 ORDER BY round DESC LIMIT 1`, round).Scan(
+		&t.Round, &t.Online.Money, &t.Online.RewardUnits, &t.Offline.Money, &t.Offline.RewardUnits, &t.NotParticipating.Money, &t.NotParticipating.RewardUnits, &t.RewardsLevel)
+	return
 }
 
 func (db *postgresIndexerDb) GetBlock(round uint64) (block types.Block, err error) {
@@ -425,7 +1226,22 @@ func (db *postgresIndexerDb) TransactionsForAddress(ctx context.Context, addr ty
 
 const maxAccountsLimit = 1000
 
-func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.Address, limit int) (accounts []models.Account, err error) {
+// setAccountStatus fills in Status and Participation like algod
+func setAccountStatus(account *Account, ad types.AccountData) {
+	account.Status = types.StatusString(ad.Status)
+	if ad.Status != types.Online {
+		return
+	}
+	account.Participation = &Participation{
+		ParticipationPK: ad.VoteID[:],
+		VRFPK:           ad.SelectionID[:],
+		VoteFirst:       uint64(ad.VoteFirstValid),
+		VoteLast:        uint64(ad.VoteLastValid),
+		VoteKeyDilution: ad.VoteKeyDilution,
+	}
+}
+
+func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.Address, limit int, includeClosed bool) (accounts []Account, err error) {
 	if limit == 0 || limit > maxAccountsLimit {
 		limit = maxAccountsLimit
 	}
@@ -434,17 +1250,19 @@ func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.
 		return
 	}
 	defer tx.Rollback()
-	roundrow := tx.QueryRow(`SELECT (v -> 'account_round')::bigint FROM metastate WHERE k = 'state'`)
+	// pending rewards are as of the latest block, accounting is expected to be caught up with import
+	roundrow := tx.QueryRow(`SELECT round, rewardslevel FROM block_header ORDER BY round DESC LIMIT 1`)
 	var round uint64
-	err = roundrow.Scan(&round)
+	var rewardsLevel uint64
+	err = roundrow.Scan(&round, &rewardsLevel)
 	if err != nil {
 		return
 	}
-	rows, err := tx.QueryContext(ctx, `SELECT addr, microalgos, rewardsbase, account_data FROM account WHERE addr > $1 ORDER BY addr LIMIT $2`, greaterThan[:], limit)
+	rows, err := tx.QueryContext(ctx, `SELECT addr, microalgos, rewardsbase, account_data FROM account WHERE addr > $1 AND ($3 OR NOT deleted) ORDER BY addr LIMIT $2`, greaterThan[:], limit, includeClosed)
 	if err != nil {
 		return
 	}
-	out := make([]models.Account, 0, limit)
+	out := make([]Account, 0, limit)
 	for rows.Next() {
 		var addr []byte
 		var microalgos uint64
@@ -454,7 +1272,7 @@ func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.
 		if err != nil {
 			return
 		}
-		var account models.Account
+		var account Account
 		account.Round = round
 		var aaddr atypes.Address
 		if len(addr) != 32 {
@@ -463,9 +1281,17 @@ func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.
 		copy(aaddr[:], addr)
 		account.Address = aaddr.String()
 		account.AmountWithoutPendingRewards = microalgos
-		// todo, get rewardslevel at round, calculate PendingRewards and add to get Amount
 		// account.Rewards // not filled
-		// account.Status // not filled
+		var ad types.AccountData
+		if dataJsonStr != nil {
+			err = json.Decode([]byte(*dataJsonStr), &ad)
+			if err != nil {
+				return nil, fmt.Errorf("bad account_data for %s, %v", account.Address, err)
+			}
+		}
+		setAccountStatus(&account, ad)
+		account.PendingRewards = uint64(types.PendingRewards(ad.Status, types.MicroAlgos(microalgos), rewardsbase, rewardsLevel))
+		account.Amount = microalgos + account.PendingRewards
 		// account.AssetParams // TODO: optionally join with asset created by this addr
 		// account.Assets // TODO: optionally join with account_asset
 		out = append(out, account)
@@ -474,6 +1300,207 @@ func (db *postgresIndexerDb) GetAccounts(ctx context.Context, greaterThan types.
 	return out, nil
 }
 
+// GetAccountAtRound is part of idb.IndexerDb
+func (db *postgresIndexerDb) GetAccountAtRound(ctx context.Context, addr types.Address, round uint64) (account Account, err error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	var microalgos uint64
+	var rewardsbase uint64
+	var dataJsonStr *string
+	var deleted bool
+	err = tx.QueryRowContext(ctx, `SELECT microalgos, rewardsbase, account_data, deleted FROM account_history WHERE addr = $1 AND round <= $2 ORDER BY round DESC LIMIT 1`, addr[:], round).Scan(&microalgos, &rewardsbase, &dataJsonStr, &deleted)
+	if err != nil {
+		return
+	}
+	// pending rewards as of the last block at or before round
+	var rewardsLevel uint64
+	err = tx.QueryRowContext(ctx, `SELECT round, rewardslevel FROM block_header WHERE round <= $1 ORDER BY round DESC LIMIT 1`, round).Scan(&account.Round, &rewardsLevel)
+	if err != nil {
+		return
+	}
+	account.Address = atypes.Address(addr).String()
+	account.AmountWithoutPendingRewards = microalgos
+	var ad types.AccountData
+	if dataJsonStr != nil {
+		err = json.Decode([]byte(*dataJsonStr), &ad)
+		if err != nil {
+			return account, fmt.Errorf("bad account_data for %s, %v", account.Address, err)
+		}
+	}
+	setAccountStatus(&account, ad)
+	account.PendingRewards = uint64(types.PendingRewards(ad.Status, types.MicroAlgos(microalgos), rewardsbase, rewardsLevel))
+	account.Amount = microalgos + account.PendingRewards
+
+	rows, err := tx.QueryContext(ctx, `SELECT h.assetid, h.amount, h.frozen, a.creator_addr FROM (SELECT DISTINCT ON (assetid) assetid, amount, frozen, deleted FROM account_asset_history WHERE addr = $1 AND round <= $2 ORDER BY assetid, round DESC) h LEFT JOIN asset a ON a.index = h.assetid WHERE NOT h.deleted`, addr[:], round)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var assetid uint64
+		var holding models.AssetHolding
+		var creator []byte
+		err = rows.Scan(&assetid, &holding.Amount, &holding.Frozen, &creator)
+		if err != nil {
+			return
+		}
+		if len(creator) == 32 {
+			var caddr atypes.Address
+			copy(caddr[:], creator)
+			holding.Creator = caddr.String()
+		}
+		if account.Assets == nil {
+			account.Assets = make(map[uint64]models.AssetHolding)
+		}
+		account.Assets[assetid] = holding
+	}
+	err = rows.Err()
+	return
+}
+
+// GetAssetHolders is part of idb.IndexerDb
+func (db *postgresIndexerDb) GetAssetHolders(ctx context.Context, assetid, round uint64, greaterThan types.Address, limit int) (holders []AssetHolder, err error) {
+	limit = AssetHoldersLimit(limit)
+	rows, err := db.db.QueryContext(ctx, `SELECT addr, amount, frozen FROM (SELECT DISTINCT ON (addr) addr, amount, frozen, deleted FROM account_asset_history WHERE assetid = $1 AND round <= $2 AND addr > $3 ORDER BY addr, round DESC) h WHERE NOT deleted ORDER BY addr LIMIT $4`, assetid, round, greaterThan[:], limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	holders = make([]AssetHolder, 0, limit)
+	for rows.Next() {
+		var addr []byte
+		var holder AssetHolder
+		err = rows.Scan(&addr, &holder.Amount, &holder.Frozen)
+		if err != nil {
+			return
+		}
+		if len(addr) != len(holder.Address) {
+			return nil, errors.New("loaded invalid addr from db in GetAssetHolders")
+		}
+		copy(holder.Address[:], addr)
+		holders = append(holders, holder)
+	}
+	err = rows.Err()
+	return
+}
+
+// reaccountSchema holds the tables accounting writes while they are rebuilt from txns, see StartReaccount()
+const reaccountSchema = "reaccount"
+
+// liveTables are the ones setup_postgres.sql creates that accounting doesn't write
+var liveTables = []string{"block_header", "txn", "txn_participation"}
+
+// derivedTables are the ones accounting writes, swapped in by FinishReaccount(). metastate only has its "state" copied.
+var derivedTables = []string{"account", "account_asset", "asset", "account_totals", "account_lifecycle", "account_history", "account_asset_history"}
+
+// withSearchPath adds a search_path for the server to a lib/pq connection string
+func withSearchPath(connection, searchPath string) (string, error) {
+	if strings.HasPrefix(connection, "postgres://") || strings.HasPrefix(connection, "postgresql://") {
+		var err error
+		connection, err = pq.ParseURL(connection)
+		if err != nil {
+			return "", err
+		}
+	}
+	quoted := strings.Replace(strings.Replace(searchPath, `\`, `\\`, -1), `'`, `\'`, -1)
+	return connection + " search_path='" + quoted + "'", nil
+}
+
+// StartReaccount is part of idb.Reaccounter
+func (db *postgresIndexerDb) StartReaccount() (shadow IndexerDb, err error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback() // ignored if .Commit() first
+	var searchPath string
+	err = tx.QueryRow(`SELECT current_setting('search_path')`).Scan(&searchPath)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`DROP SCHEMA IF EXISTS ` + reaccountSchema + ` CASCADE; CREATE SCHEMA ` + reaccountSchema)
+	if err != nil {
+		return nil, fmt.Errorf("reaccount schema, %v", err)
+	}
+	// the same tables and indexes as the live ones, without the ones that aren't rebuilt
+	_, err = tx.Exec(`SET LOCAL search_path TO ` + reaccountSchema)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(setup_postgres_sql)
+	if err != nil {
+		return nil, fmt.Errorf("reaccount tables, %v", err)
+	}
+	_, err = tx.Exec(`DROP TABLE ` + strings.Join(liveTables, ", "))
+	if err != nil {
+		return nil, fmt.Errorf("reaccount tables, %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	// the rebuilt tables hide the live ones, txn and block_header are still found in the live schema
+	connection, err := withSearchPath(db.connection, reaccountSchema+", "+searchPath)
+	if err != nil {
+		return
+	}
+	sdb, err := sql.Open("postgres", connection)
+	if err != nil {
+		return
+	}
+	return &postgresIndexerDb{db: sdb, connection: connection}, nil
+}
+
+// FinishReaccount is part of idb.Reaccounter
+func (db *postgresIndexerDb) FinishReaccount(force bool) (err error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback() // ignored if .Commit() first
+	// live accounting waits until the swap is done
+	live, err := lockAccounting(tx)
+	if err != nil {
+		return
+	}
+	var stateJsonStr string
+	err = tx.QueryRow(`SELECT v FROM ` + reaccountSchema + `.metastate WHERE k = 'state'`).Scan(&stateJsonStr)
+	if err != nil {
+		return fmt.Errorf("rebuilt state, %v", err)
+	}
+	rebuilt, err := ParseImportState(stateJsonStr)
+	if err != nil {
+		return
+	}
+	if live.AccountRound > rebuilt.AccountRound && !force {
+		return ErrReaccountBehind
+	}
+	var liveSchema string
+	err = tx.QueryRow(`SELECT n.nspname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.oid = 'account'::regclass`).Scan(&liveSchema)
+	if err != nil {
+		return fmt.Errorf("live schema, %v", err)
+	}
+	for _, table := range derivedTables {
+		_, err = tx.Exec(`DROP TABLE ` + pq.QuoteIdentifier(liveSchema) + `.` + table + `; ALTER TABLE ` + reaccountSchema + `.` + table + ` SET SCHEMA ` + pq.QuoteIdentifier(liveSchema))
+		if err != nil {
+			return fmt.Errorf("swapping %s, %v", table, err)
+		}
+	}
+	err = setImportState(tx, rebuilt)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`DROP SCHEMA ` + reaccountSchema + ` CASCADE`)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
 type postgresFactory struct {
 }
 
@@ -487,12 +1514,3 @@ func (df postgresFactory) Build(arg string) (IndexerDb, error) {
 func init() {
 	indexerFactories = append(indexerFactories, &postgresFactory{})
 }
-
-type ImportState struct {
-	AccountRound int64 `codec:"account_round"`
-}
-
-func ParseImportState(js string) (istate ImportState, err error) {
-	err = json.Decode([]byte(js), &istate)
-	return
-}
@@ -0,0 +1,442 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build !nopostgres
+
+package idb
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/algorand/indexer/types"
+)
+
+func TestCheckAccountRound(t *testing.T) {
+	tests := []struct {
+		accountRound int64
+		round        uint64
+		ok           bool
+	}{
+		{-1, 0, true},
+		{-1, 5, true},
+		{0, 0, false},
+		{0, 1, true},
+		{5, 3, false},
+		{5, 5, false},
+		{5, 6, true},
+	}
+	for _, tc := range tests {
+		err := checkAccountRound(ImportState{AccountRound: tc.accountRound}, tc.round)
+		if (err == nil) != tc.ok {
+			t.Errorf("accounting through %d, round %d: got %v", tc.accountRound, tc.round, err)
+		}
+	}
+}
+
+// fakeAccounting is committed account state with an accounting lock, for the accountingTx logic without postgres
+type fakeAccounting struct {
+	// lock is the advisory lock
+	lock sync.Mutex
+
+	mu       sync.Mutex
+	state    ImportState
+	balances map[[32]byte]int64
+	// applied counts applyRoundAccounting calls, committed or not
+	applied int
+	// active is the number of txs holding the lock, maxActive the most there ever were
+	active    int
+	maxActive int
+	// failHistory makes recordHistory fail, after the deltas are applied
+	failHistory bool
+}
+
+// newFakeAccounting has balances loaded as of round 0
+func newFakeAccounting(balances map[[32]byte]int64) *fakeAccounting {
+	var totals AccountTotals
+	for _, amount := range balances {
+		totals.Offline.Money += uint64(amount)
+	}
+	return &fakeAccounting{state: ImportState{AccountRound: 0, Totals: &totals}, balances: balances}
+}
+
+func (fa *fakeAccounting) begin() *fakeAccountingTx {
+	return &fakeAccountingTx{fa: fa}
+}
+
+func (fa *fakeAccounting) committed() (ImportState, map[[32]byte]int64) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	return fa.state, fa.balances
+}
+
+type fakeAccountingTx struct {
+	fa       *fakeAccounting
+	locked   bool
+	state    ImportState
+	balances map[[32]byte]int64
+}
+
+func (ft *fakeAccountingTx) lockAccounting() (ImportState, error) {
+	ft.fa.lock.Lock()
+	ft.locked = true
+	ft.fa.mu.Lock()
+	defer ft.fa.mu.Unlock()
+	ft.fa.active++
+	if ft.fa.active > ft.fa.maxActive {
+		ft.fa.maxActive = ft.fa.active
+	}
+	// read committed, after the lock
+	ft.state = ft.fa.state
+	if ft.state.Totals != nil {
+		totals := *ft.state.Totals
+		ft.state.Totals = &totals
+	}
+	ft.balances = make(map[[32]byte]int64, len(ft.fa.balances))
+	for addr, amount := range ft.fa.balances {
+		ft.balances[addr] = amount
+	}
+	return ft.state, nil
+}
+
+func (ft *fakeAccountingTx) statusTotals(addrs [][]byte) (totals AccountTotals, err error) {
+	for addr, amount := range ft.balances {
+		if addrs != nil && !participates(addrs, addr) {
+			continue
+		}
+		totals.Offline.Money += uint64(amount)
+	}
+	return
+}
+
+func participates(addrs [][]byte, addr [32]byte) bool {
+	for _, a := range addrs {
+		if string(a) == string(addr[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ft *fakeAccountingTx) applyRoundAccounting(updates RoundUpdates, round, rewardsBase uint64) (bool, error) {
+	ft.fa.mu.Lock()
+	ft.fa.applied++
+	ft.fa.mu.Unlock()
+	for addr, delta := range updates.AlgoUpdates {
+		ft.balances[addr] += delta
+	}
+	return len(updates.AlgoUpdates) > 0, nil
+}
+
+func (ft *fakeAccountingTx) recordHistory(updates RoundUpdates, round uint64, addrs [][]byte) error {
+	if ft.fa.failHistory {
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func (ft *fakeAccountingTx) setImportState(istate ImportState) error {
+	ft.state = istate
+	return nil
+}
+
+func (ft *fakeAccountingTx) end(commit bool) error {
+	if !ft.locked {
+		return nil
+	}
+	ft.locked = false
+	ft.fa.mu.Lock()
+	if commit {
+		ft.fa.state = ft.state
+		ft.fa.balances = ft.balances
+	}
+	ft.fa.active--
+	ft.fa.mu.Unlock()
+	ft.fa.lock.Unlock()
+	return nil
+}
+
+func (ft *fakeAccountingTx) Commit() error {
+	return ft.end(true)
+}
+
+func (ft *fakeAccountingTx) Rollback() error {
+	return ft.end(false)
+}
+
+func TestCommitRoundRefusedBeforeWrites(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	fa := newFakeAccounting(map[[32]byte]int64{a: 1000})
+	for round := uint64(1); round <= 3; round++ {
+		err := commitRoundTx(fa.begin(), testPayment(a, b, 10), round, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	applied := fa.applied
+	tests := []struct {
+		name    string
+		round   uint64
+		updates RoundUpdates
+	}{
+		{"last round again", 3, testPayment(a, b, 10)},
+		{"earlier round", 2, testPayment(a, b, 10)},
+		{"empty round again", 3, RoundUpdates{}},
+	}
+	for _, tc := range tests {
+		err := commitRoundTx(fa.begin(), tc.updates, tc.round, 0)
+		if err == nil || !strings.Contains(err.Error(), "already accounted") {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+	if fa.applied != applied {
+		t.Fatalf("refused rounds were applied %d times before being refused", fa.applied-applied)
+	}
+	state, balances := fa.committed()
+	if state.AccountRound != 3 || balances[a] != 970 || balances[b] != 30 {
+		t.Fatalf("round %d balances %d %d, want 3 970 30", state.AccountRound, balances[a], balances[b])
+	}
+}
+
+func TestCommitRoundSerialized(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	fa := newFakeAccounting(map[[32]byte]int64{a: 1000})
+	const writers = 8
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			// pairs of writers race for the same round
+			errs[i] = commitRoundTx(fa.begin(), testPayment(a, b, 10), uint64(i/2+1), 0)
+		}(i)
+	}
+	wg.Wait()
+	if fa.maxActive != 1 {
+		t.Fatalf("%d writers held the lock at once", fa.maxActive)
+	}
+	applied := 0
+	for _, err := range errs {
+		if err == nil {
+			applied++
+		} else if !strings.Contains(err.Error(), "already accounted") {
+			t.Error(err)
+		}
+	}
+	state, balances := fa.committed()
+	if balances[a]+balances[b] != 1000 || balances[b] != int64(applied)*10 {
+		t.Fatalf("%d rounds applied, balances %d %d", applied, balances[a], balances[b])
+	}
+	if state.AccountRound < 1 || state.AccountRound > writers/2 {
+		t.Fatalf("accounting through round %d", state.AccountRound)
+	}
+}
+
+func TestCommitRoundAbortedThenResumed(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	fa := newFakeAccounting(map[[32]byte]int64{a: 1000})
+	err := commitRoundTx(fa.begin(), testPayment(a, b, 10), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deltas for round 2 are written, then the tx dies
+	fa.failHistory = true
+	err = commitRoundTx(fa.begin(), testPayment(a, b, 10), 2, 0)
+	if err == nil {
+		t.Fatal("round 2 committed without its history")
+	}
+	state, balances := fa.committed()
+	if state.AccountRound != 1 || balances[a] != 990 {
+		t.Fatalf("aborted round left round %d balance %d, want 1 990", state.AccountRound, balances[a])
+	}
+	// on restart accounting carries on after the last committed round
+	fa.failHistory = false
+	err = commitRoundTx(fa.begin(), testPayment(a, b, 10), uint64(state.AccountRound+1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, balances = fa.committed()
+	if state.AccountRound != 2 || balances[a] != 980 || balances[b] != 20 {
+		t.Fatalf("round %d balances %d %d, want 2 980 20", state.AccountRound, balances[a], balances[b])
+	}
+	if state.Totals.Round != 2 || state.Totals.Money() != 1000 {
+		t.Fatalf("totals round %d money %d", state.Totals.Round, state.Totals.Money())
+	}
+}
+
+// testPostgres opens the scratch database named by INDEXER_TEST_POSTGRES with account state loaded as of round 0
+func testPostgres(t *testing.T, accounts []AccountState) (db IndexerDb, raw *sql.DB) {
+	connection := os.Getenv("INDEXER_TEST_POSTGRES")
+	if connection == "" {
+		t.Skip("INDEXER_TEST_POSTGRES not set")
+	}
+	db, err := OpenPostgres(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err = sql.Open("postgres", connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	err = db.LoadAccounts(0, 0, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, raw
+}
+
+func testAddr(b byte) (addr types.Address) {
+	addr[0] = b
+	return
+}
+
+func testPayment(from, to types.Address, amount int64) RoundUpdates {
+	return RoundUpdates{AlgoUpdates: map[[32]byte]int64{from: -amount, to: amount}}
+}
+
+func microalgos(t *testing.T, raw *sql.DB, addr types.Address) (amount int64) {
+	err := raw.QueryRow(`SELECT microalgos FROM account WHERE addr = $1`, addr[:]).Scan(&amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCommitRoundAccountingRefusesAccountedRound(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	db, raw := testPostgres(t, []AccountState{{Address: a, Data: types.AccountData{MicroAlgos: 1000}}})
+	err := db.CommitRoundAccounting(testPayment(a, b, 10), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, round := range []uint64{0, 1} {
+		err = db.CommitRoundAccounting(testPayment(a, b, 10), round, 0)
+		if err == nil || !strings.Contains(err.Error(), "already accounted") {
+			t.Fatalf("round %d again: got %v", round, err)
+		}
+	}
+	if microalgos(t, raw, a) != 990 || microalgos(t, raw, b) != 10 {
+		t.Fatalf("refused rounds changed balances")
+	}
+	err = db.CommitRoundAccounting(testPayment(a, b, 10), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if microalgos(t, raw, a) != 980 {
+		t.Fatalf("round 2 not applied")
+	}
+}
+
+func TestCommitRoundAccountingWaitsForLock(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	db, raw := testPostgres(t, []AccountState{{Address: a, Data: types.AccountData{MicroAlgos: 1000}}})
+	holder, err := raw.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Rollback()
+	_, err = holder.Exec(`SELECT pg_advisory_xact_lock($1)`, accountingLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- db.CommitRoundAccounting(testPayment(a, b, 10), 1, 0)
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("committed while another writer held the lock: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	err = holder.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentCommitRoundAccounting(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	db, raw := testPostgres(t, []AccountState{{Address: a, Data: types.AccountData{MicroAlgos: 1000}}})
+	const writers = 4
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = db.CommitRoundAccounting(testPayment(a, b, 10), 1, 0)
+		}(i)
+	}
+	wg.Wait()
+	applied := 0
+	for _, err := range errs {
+		if err == nil {
+			applied++
+		} else if !strings.Contains(err.Error(), "already accounted") {
+			t.Error(err)
+		}
+	}
+	if applied != 1 {
+		t.Fatalf("round 1 applied %d times", applied)
+	}
+	if microalgos(t, raw, a) != 990 || microalgos(t, raw, b) != 10 {
+		t.Fatalf("balances %d %d, want 990 10", microalgos(t, raw, a), microalgos(t, raw, b))
+	}
+}
+
+func TestCommitRoundAccountingAbortedInPostgres(t *testing.T) {
+	a, b := testAddr(1), testAddr(2)
+	db, raw := testPostgres(t, []AccountState{{Address: a, Data: types.AccountData{MicroAlgos: 1000}}})
+	err := db.CommitRoundAccounting(testPayment(a, b, 10), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fails after the deltas are written
+	broken := RoundUpdates{AlgoUpdates: map[[32]byte]int64{a: -10, b: 20}}
+	err = db.CommitRoundAccounting(broken, 2, 0)
+	if err == nil || !strings.Contains(err.Error(), "conserve") {
+		t.Fatalf("got %v", err)
+	}
+	if microalgos(t, raw, a) != 990 || microalgos(t, raw, b) != 10 {
+		t.Fatalf("aborted round changed balances")
+	}
+	stateJsonStr, err := db.GetMetastate("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	istate, err := ParseImportState(stateJsonStr)
+	if err != nil || istate.AccountRound != 1 {
+		t.Fatalf("accounting through %d %v, want 1", istate.AccountRound, err)
+	}
+	err = db.CommitRoundAccounting(testPayment(a, b, 10), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if microalgos(t, raw, a) != 980 || microalgos(t, raw, b) != 20 {
+		t.Fatalf("round 2 not applied once")
+	}
+}
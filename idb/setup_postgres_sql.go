@@ -64,28 +64,42 @@ intra smallint NOT NULL
 );
 CREATE INDEX IF NOT EXISTS txn_participation_i ON txn_participation ( addr, round DESC, intra DESC );
 
--- bookeeping for local file import
-CREATE TABLE IF NOT EXISTS imported (path text);
-
 -- like ledger/accountdb.go
 DROP TABLE IF EXISTS accounttotals;
--- TODO: do we need this?
--- CREATE TABLE IF NOT EXISTS accounttotals (
---   id text primary key,
---   online integer,
---   onlinerewardunits integer,
---   offline integer,
---   offlinerewardunits integer,
---   notparticipating integer,
---   notparticipatingrewardunits integer,
---   rewardslevel integer);
+
+-- metastate "state" totals after each round that changed any account
+-- money doesn't include pending rewards, they stay in the rewards pool until realized, so the sum of money is the total supply
+CREATE TABLE IF NOT EXISTS account_totals (
+  round bigint PRIMARY KEY,
+  online bigint NOT NULL,
+  onlinerewardunits bigint NOT NULL,
+  offline bigint NOT NULL,
+  offlinerewardunits bigint NOT NULL,
+  notparticipating bigint NOT NULL,
+  notparticipatingrewardunits bigint NOT NULL,
+  rewardslevel bigint NOT NULL
+);
 
 -- expand data.basics.AccountData
 CREATE TABLE IF NOT EXISTS account (
   addr bytea primary key,
   microalgos bigint NOT NULL,
   rewardsbase bigint NOT NULL,
-  account_data jsonb -- data.basics.AccountData except AssetParams and Assets and MicroAlgos and RewardsBase
+  account_data jsonb, -- data.basics.AccountData except AssetParams and Assets and MicroAlgos and RewardsBase
+  created_at bigint, -- round the account first appeared, 0 for genesis, NULL if unknown
+  closed_at bigint, -- round the account was last closed
+  deleted boolean NOT NULL DEFAULT false -- closed and not reopened since
+);
+ALTER TABLE account ADD COLUMN IF NOT EXISTS created_at bigint;
+ALTER TABLE account ADD COLUMN IF NOT EXISTS closed_at bigint;
+ALTER TABLE account ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false;
+
+-- account creation (1), close (2) and reopen (3) events
+CREATE TABLE IF NOT EXISTS account_lifecycle (
+  addr bytea NOT NULL,
+  round bigint NOT NULL,
+  event smallint NOT NULL,
+  PRIMARY KEY (addr, round, event)
 );
 
 -- data.basics.AccountData Assets[asset id] AssetHolding{}
@@ -97,16 +111,40 @@ CREATE TABLE IF NOT EXISTS account_asset (
   PRIMARY KEY (addr, assetid)
 );
 
+-- account as of the end of each round that changed it, for balances at past rounds
+CREATE TABLE IF NOT EXISTS account_history (
+  addr bytea NOT NULL,
+  round bigint NOT NULL,
+  microalgos bigint NOT NULL,
+  rewardsbase bigint NOT NULL,
+  account_data jsonb,
+  deleted boolean NOT NULL,
+  PRIMARY KEY (addr, round)
+);
+
+-- account_asset as of the end of each round that changed it, deleted when the holding was closed or the asset destroyed
+CREATE TABLE IF NOT EXISTS account_asset_history (
+  addr bytea NOT NULL,
+  assetid bigint NOT NULL,
+  round bigint NOT NULL,
+  amount bigint NOT NULL,
+  frozen boolean NOT NULL,
+  deleted boolean NOT NULL,
+  PRIMARY KEY (addr, assetid, round)
+);
+CREATE INDEX IF NOT EXISTS account_asset_history_asset ON account_asset_history (assetid, addr, round DESC);
+
 -- data.basics.AccountData AssetParams[index] AssetParams{}
 CREATE TABLE IF NOT EXISTS asset (
   index bigint PRIMARY KEY,
   creator_addr bytea NOT NULL,
   params jsonb NOT NULL -- data.basics.AssetParams -- TODO index some fields?
 );
--- TODO: index on creator_addr
+CREATE INDEX IF NOT EXISTS asset_creator ON asset (creator_addr);
 
 -- subsumes ledger/accountdb.go accounttotals and acctrounds
--- "state":{online, onlinerewardunits, offline, offlinerewardunits, notparticipating, notparticipatingrewardunits, rewardslevel, round bigint}
+-- "state":{account_round, txn_counter, totals:{round, online:{money, units}, offline:{money, units}, notpart:{money, units}, rwd}}
+-- "import":{contiguous_round bigint} every round through contiguous_round is in block_header
 CREATE TABLE IF NOT EXISTS metastate (
   k text primary key,
   v jsonb
@@ -19,7 +19,9 @@ package importer
 import (
 	"bytes"
 	"fmt"
+	"sync"
 
+	"github.com/algorand/indexer/algobot"
 	"github.com/algorand/indexer/idb"
 	"github.com/algorand/indexer/types"
 
@@ -63,7 +65,36 @@ func NewPrintImporter() Importer {
 }
 
 type dbImporter struct {
-	db idb.IndexerDb
+	db       idb.IndexerDb
+	imported *importedRounds
+	verifier *BlockVerifier
+}
+
+// importedRounds knows which blocks are already in the db, whatever file they were imported from
+type importedRounds struct {
+	db         idb.IndexerDb
+	once       sync.Once
+	contiguous int64
+	err        error
+}
+
+func newImportedRounds(db idb.IndexerDb) *importedRounds {
+	return &importedRounds{db: db}
+}
+
+func (ir *importedRounds) has(round uint64) (bool, error) {
+	ir.once.Do(func() {
+		var progress idb.ImportProgress
+		progress, ir.err = ir.db.GetImportProgress()
+		ir.contiguous = progress.ContiguousRound
+	})
+	if ir.err != nil {
+		return false, ir.err
+	}
+	if int64(round) <= ir.contiguous {
+		return true, nil
+	}
+	return ir.db.HasBlock(round)
 }
 
 type stringInt struct {
@@ -96,18 +127,48 @@ func participate(participants [][]byte, addr []byte) [][]byte {
 	return participants
 }
 
-func (imp *dbImporter) ImportBlock(blockbytes []byte) (err error) {
+// decodedTxn is a txn ready for IndexerDb.AddTransaction()
+type decodedTxn struct {
+	intra         int
+	typeenum      int
+	assetid       uint64
+	txnbytes      []byte
+	stxn          types.SignedTxnInBlock
+	participation [][]byte
+}
+
+// decodedBlock is a block ready to be written to an IndexerDb
+type decodedBlock struct {
+	round        uint64
+	timestamp    int64
+	rewardslevel uint64
+	headerbytes  []byte
+	header       types.BlockHeader
+	txns         []decodedTxn
+}
+
+// decodeBlock does all the work of importing a block that doesn't need the db
+// If verify is set the block's TxnRoot is checked against its payset.
+func decodeBlock(blockbytes []byte, verify bool) (out *decodedBlock, err error) {
 	var blockContainer types.EncodedBlockCert
 	err = msgpack.Decode(blockbytes, &blockContainer)
 	if err != nil {
-		return fmt.Errorf("error decoding blockbytes, %v", err)
-	}
-	err = imp.db.StartBlock()
-	if err != nil {
-		return fmt.Errorf("error starting block, %v", err)
+		return nil, fmt.Errorf("error decoding blockbytes, %v", err)
 	}
 	block := blockContainer.Block
-	round := uint64(block.Round)
+	if verify {
+		err = verifyTxnRoot(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out = &decodedBlock{
+		round:        uint64(block.Round),
+		header:       block.BlockHeader,
+		timestamp:    block.TimeStamp,
+		rewardslevel: block.RewardsLevel,
+		txns:         make([]decodedTxn, len(block.Payset)),
+	}
 	for intra, stxn := range block.Payset {
 		txtype := string(stxn.Txn.Type)
 		txtypeenum := typeEnumMap[txtype]
@@ -120,7 +181,6 @@ func (imp *dbImporter) ImportBlock(blockbytes []byte) (err error) {
 		case 5:
 			assetid = uint64(stxn.Txn.FreezeAsset)
 		}
-		txnbytes := msgpack.Encode(stxn)
 		participants := make([][]byte, 0, 10)
 		participants = participate(participants, stxn.Txn.Sender[:])
 		participants = participate(participants, stxn.Txn.Receiver[:])
@@ -128,21 +188,114 @@ func (imp *dbImporter) ImportBlock(blockbytes []byte) (err error) {
 		participants = participate(participants, stxn.Txn.AssetSender[:])
 		participants = participate(participants, stxn.Txn.AssetReceiver[:])
 		participants = participate(participants, stxn.Txn.AssetCloseTo[:])
-		err = imp.db.AddTransaction(round, intra, txtypeenum, assetid, txnbytes, stxn, participants)
-		if err != nil {
-			return fmt.Errorf("error importing txn r=%d i=%d, %v", round, intra, err)
+		out.txns[intra] = decodedTxn{
+			intra:         intra,
+			typeenum:      txtypeenum,
+			assetid:       assetid,
+			txnbytes:      msgpack.Encode(stxn),
+			stxn:          stxn,
+			participation: participants,
 		}
 	}
 	blockHeader := block
 	blockHeader.Payset = nil
-	blockheaderBytes := msgpack.Encode(blockHeader)
-	err = imp.db.CommitBlock(round, block.TimeStamp, block.RewardsLevel, blockheaderBytes)
+	out.headerbytes = msgpack.Encode(blockHeader)
+	return out, nil
+}
+
+// BlockAccounter computes the account changes of a block so they can be written in the same db transaction as the block.
+// accounting.AccountingState is one.
+type BlockAccounter interface {
+	AddBlock(header types.BlockHeader, payset []types.SignedTxnInBlock) (updates idb.RoundUpdates, err error)
+}
+
+// writeBlocks writes blocks to the db in one transaction, with their account changes if acct is not nil
+func writeBlocks(db idb.IndexerDb, blocks []*decodedBlock, acct BlockAccounter) (err error) {
+	if len(blocks) == 0 {
+		return nil
+	}
+	err = db.StartBlock()
 	if err != nil {
-		return fmt.Errorf("error committing block, %v", err)
+		return fmt.Errorf("error starting block, %v", err)
+	}
+	for bi, block := range blocks {
+		for _, txn := range block.txns {
+			err = db.AddTransaction(block.round, txn.intra, txn.typeenum, txn.assetid, txn.txnbytes, txn.stxn, txn.participation)
+			if err != nil {
+				return fmt.Errorf("error importing txn r=%d i=%d, %v", block.round, txn.intra, err)
+			}
+		}
+		if acct != nil {
+			payset := make([]types.SignedTxnInBlock, len(block.txns))
+			for i, txn := range block.txns {
+				payset[i] = txn.stxn
+			}
+			updates, err := acct.AddBlock(block.header, payset)
+			if err != nil {
+				return fmt.Errorf("error accounting r=%d, %v", block.round, err)
+			}
+			err = db.AddRoundAccounting(updates, block.round, block.rewardslevel)
+			if err != nil {
+				return fmt.Errorf("error adding accounting r=%d, %v", block.round, err)
+			}
+		}
+		if bi == len(blocks)-1 {
+			err = db.CommitBlock(block.round, block.timestamp, block.rewardslevel, block.headerbytes)
+			if err != nil {
+				return fmt.Errorf("error committing block, %v", err)
+			}
+		} else {
+			err = db.AddBlockHeader(block.round, block.timestamp, block.rewardslevel, block.headerbytes)
+			if err != nil {
+				return fmt.Errorf("error adding block header r=%d, %v", block.round, err)
+			}
+		}
 	}
 	return nil
 }
 
+func (imp *dbImporter) ImportBlock(blockbytes []byte) (err error) {
+	block, err := decodeBlock(blockbytes, imp.verifier != nil)
+	if err != nil {
+		return
+	}
+	have, err := imp.imported.has(block.round)
+	if err != nil || have {
+		return
+	}
+	if imp.verifier != nil {
+		err = imp.verifier.Verify(block.header)
+		if err != nil {
+			return
+		}
+	}
+	return writeBlocks(imp.db, []*decodedBlock{block}, nil)
+}
+
+// HandleRawBlock is part of algobot.RawBlockHandler
+func (imp *dbImporter) HandleRawBlock(bot algobot.Algobot, blockbytes []byte) error {
+	return imp.ImportBlock(blockbytes)
+}
+
+// NextRound is part of algobot.NextRounder
+func (imp *dbImporter) NextRound() (round uint64, err error) {
+	maxRound, err := imp.db.GetMaxRound()
+	if err != nil {
+		return
+	}
+	return uint64(maxRound + 1), nil
+}
+
 func NewDBImporter(db idb.IndexerDb) Importer {
-	return &dbImporter{db: db}
+	return &dbImporter{db: db, imported: newImportedRounds(db)}
+}
+
+// NewVerifyingDBImporter is NewDBImporter but rejects blocks that fail bv checks
+func NewVerifyingDBImporter(db idb.IndexerDb, bv *BlockVerifier) Importer {
+	return &dbImporter{db: db, imported: newImportedRounds(db), verifier: bv}
+}
+
+// NewDBBlockHandler returns the same importer as NewDBImporter for use with an algobot.Runner
+func NewDBBlockHandler(db idb.IndexerDb) algobot.RawBlockHandler {
+	return &dbImporter{db: db, imported: newImportedRounds(db)}
 }
@@ -0,0 +1,309 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/algorand/indexer/idb"
+)
+
+// Flusher is implemented by Importers that write asynchronously.
+// Flush returns when everything passed to ImportBlock so far is committed.
+type Flusher interface {
+	Flush() error
+}
+
+// ImportStats is a throughput report for a ParallelImporter
+type ImportStats struct {
+	Blocks int
+	Txns   int
+	Bytes  int64
+
+	// Skipped blocks were already imported
+	Skipped int
+
+	Start time.Time
+	End   time.Time
+
+	// DecodeTime is summed over all workers
+	DecodeTime time.Duration
+	WriteTime  time.Duration
+}
+
+func (stats ImportStats) String() string {
+	dt := stats.End.Sub(stats.Start)
+	seconds := dt.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	return fmt.Sprintf("%d blocks, %d txns, %.1f MB in %s: %.1f blocks/s, %.1f txns/s, %.2f MB/s (decode %s total over workers, db write %s, %d blocks already imported)",
+		stats.Blocks, stats.Txns, float64(stats.Bytes)/1000000.0, dt.Round(time.Millisecond),
+		float64(stats.Blocks)/seconds, float64(stats.Txns)/seconds, float64(stats.Bytes)/1000000.0/seconds,
+		stats.DecodeTime.Round(time.Millisecond), stats.WriteTime.Round(time.Millisecond), stats.Skipped)
+}
+
+type pipelineBlock struct {
+	seq        uint64
+	blockbytes []byte
+	block      *decodedBlock
+	err        error
+	// already imported
+	skip bool
+
+	// flush markers are passed through the pipeline in order and closed when reached
+	flush chan error
+}
+
+// ParallelImporter decodes blocks on worker goroutines and writes them to the db in the order they were given to ImportBlock.
+// Up to blocksPerTxn blocks are written in each db transaction.
+type ParallelImporter struct {
+	db           idb.IndexerDb
+	blocksPerTxn int
+	imported     *importedRounds
+	verifier     *BlockVerifier
+
+	// inline accounting, see SetAccounting()
+	acct         BlockAccounter
+	accountRound int64
+
+	in      chan *pipelineBlock
+	decoded chan *pipelineBlock
+	// bounds the number of blocks in flight
+	window chan struct{}
+
+	nextSeq uint64
+	workers sync.WaitGroup
+	writer  sync.WaitGroup
+
+	l     sync.Mutex
+	err   error
+	stats ImportStats
+}
+
+func NewParallelImporter(db idb.IndexerDb, workers, blocksPerTxn int) *ParallelImporter {
+	if workers < 1 {
+		workers = 1
+	}
+	if blocksPerTxn < 1 {
+		blocksPerTxn = 1
+	}
+	pi := &ParallelImporter{
+		db:           db,
+		blocksPerTxn: blocksPerTxn,
+		imported:     newImportedRounds(db),
+		in:           make(chan *pipelineBlock, workers),
+		decoded:      make(chan *pipelineBlock, workers),
+		window:       make(chan struct{}, (workers*2)+blocksPerTxn),
+	}
+	pi.stats.Start = time.Now()
+	pi.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pi.decodeThread()
+	}
+	pi.writer.Add(1)
+	go pi.writeThread()
+	return pi
+}
+
+// SetVerifier checks every block with bv before it is written. Call before the first ImportBlock.
+func (pi *ParallelImporter) SetVerifier(bv *BlockVerifier) {
+	pi.verifier = bv
+}
+
+// SetAccounting makes account changes be written in the same db transaction as each block.
+// Accounting must be done through accountRound and blocks must then arrive in round order with no gaps.
+// Call before the first ImportBlock.
+func (pi *ParallelImporter) SetAccounting(acct BlockAccounter, accountRound int64) {
+	pi.acct = acct
+	pi.accountRound = accountRound
+}
+
+// ImportBlock queues a block. An error from an earlier block is returned here or from Flush/Close.
+func (pi *ParallelImporter) ImportBlock(blockbytes []byte) error {
+	err := pi.getErr()
+	if err != nil {
+		return err
+	}
+	pi.window <- struct{}{}
+	pi.in <- &pipelineBlock{seq: pi.nextSeq, blockbytes: blockbytes}
+	pi.nextSeq++
+	return nil
+}
+
+// Flush is part of Flusher
+func (pi *ParallelImporter) Flush() error {
+	flush := make(chan error, 1)
+	pi.in <- &pipelineBlock{seq: pi.nextSeq, flush: flush}
+	pi.nextSeq++
+	return <-flush
+}
+
+// Close writes everything queued and stops the workers.
+func (pi *ParallelImporter) Close() error {
+	close(pi.in)
+	pi.workers.Wait()
+	close(pi.decoded)
+	pi.writer.Wait()
+	pi.l.Lock()
+	defer pi.l.Unlock()
+	pi.stats.End = time.Now()
+	return pi.err
+}
+
+// Stats returns the throughput so far
+func (pi *ParallelImporter) Stats() ImportStats {
+	pi.l.Lock()
+	defer pi.l.Unlock()
+	stats := pi.stats
+	if stats.End.IsZero() {
+		stats.End = time.Now()
+	}
+	return stats
+}
+
+func (pi *ParallelImporter) getErr() error {
+	pi.l.Lock()
+	defer pi.l.Unlock()
+	return pi.err
+}
+
+func (pi *ParallelImporter) setErr(err error) {
+	pi.l.Lock()
+	defer pi.l.Unlock()
+	if pi.err == nil {
+		pi.err = err
+	}
+}
+
+func (pi *ParallelImporter) decodeThread() {
+	defer pi.workers.Done()
+	for pb := range pi.in {
+		if pb.flush == nil {
+			start := time.Now()
+			pb.block, pb.err = decodeBlock(pb.blockbytes, pi.verifier != nil)
+			if pb.err == nil {
+				pb.skip, pb.err = pi.imported.has(pb.block.round)
+			}
+			dt := time.Now().Sub(start)
+			pi.l.Lock()
+			pi.stats.DecodeTime += dt
+			pi.stats.Bytes += int64(len(pb.blockbytes))
+			pi.l.Unlock()
+			pb.blockbytes = nil
+		}
+		pi.decoded <- pb
+	}
+}
+
+// writeThread puts decoded blocks back in order and writes them in batches
+func (pi *ParallelImporter) writeThread() {
+	defer pi.writer.Done()
+	pending := make(map[uint64]*pipelineBlock)
+	next := uint64(0)
+	batch := make([]*decodedBlock, 0, pi.blocksPerTxn)
+	for pb := range pi.decoded {
+		pending[pb.seq] = pb
+		for {
+			pb, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if pb.flush != nil {
+				pi.writeBatch(batch)
+				batch = batch[:0]
+				pb.flush <- pi.getErr()
+				continue
+			}
+			if pb.err != nil {
+				pi.setErr(pb.err)
+			} else if err := pi.checkAccountRound(pb.block.round, pb.skip); err != nil {
+				pi.setErr(err)
+			} else if pb.skip {
+				pi.l.Lock()
+				pi.stats.Skipped++
+				pi.l.Unlock()
+			} else if err := pi.verifyBlock(pb.block); err != nil {
+				pi.setErr(err)
+			} else {
+				batch = append(batch, pb.block)
+				if len(batch) >= pi.blocksPerTxn {
+					pi.writeBatch(batch)
+					batch = batch[:0]
+				}
+			}
+			<-pi.window
+		}
+	}
+	pi.writeBatch(batch)
+}
+
+// checkAccountRound makes sure inline accounting sees every round once, in order
+func (pi *ParallelImporter) checkAccountRound(round uint64, skip bool) error {
+	if pi.acct == nil {
+		return nil
+	}
+	if int64(round) <= pi.accountRound {
+		if !skip {
+			return fmt.Errorf("round %d is already accounted for but not imported", round)
+		}
+		return nil
+	}
+	if int64(round) != pi.accountRound+1 {
+		return fmt.Errorf("accounting needs round %d next, got %d", pi.accountRound+1, round)
+	}
+	if skip {
+		return fmt.Errorf("round %d is imported but not accounted for", round)
+	}
+	pi.accountRound = int64(round)
+	return nil
+}
+
+// verifyBlock runs on the write thread so blocks are checked in round order
+func (pi *ParallelImporter) verifyBlock(block *decodedBlock) error {
+	if pi.verifier == nil || pi.getErr() != nil {
+		return nil
+	}
+	return pi.verifier.Verify(block.header)
+}
+
+func (pi *ParallelImporter) writeBatch(batch []*decodedBlock) {
+	if len(batch) == 0 || pi.getErr() != nil {
+		// after an error, drain without writing
+		return
+	}
+	start := time.Now()
+	err := writeBlocks(pi.db, batch, pi.acct)
+	dt := time.Now().Sub(start)
+	pi.l.Lock()
+	defer pi.l.Unlock()
+	pi.stats.WriteTime += dt
+	if err != nil {
+		if pi.err == nil {
+			pi.err = err
+		}
+		return
+	}
+	pi.stats.Blocks += len(batch)
+	for _, block := range batch {
+		pi.stats.Txns += len(block.txns)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build !nopostgres
+
+package importer
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/algorand/indexer/idb"
+)
+
+// testPostgres opens the scratch database named by INDEXER_TEST_POSTGRES with no blocks or txns in it
+func testPostgres(t *testing.T) (db idb.IndexerDb, raw *sql.DB) {
+	connection := os.Getenv("INDEXER_TEST_POSTGRES")
+	if connection == "" {
+		t.Skip("INDEXER_TEST_POSTGRES not set")
+	}
+	db, err := idb.OpenPostgres(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err = sql.Open("postgres", connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	_, err = raw.Exec(`TRUNCATE txn, txn_participation, block_header; DELETE FROM metastate WHERE k = 'import'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, raw
+}
+
+func TestBulkImport(t *testing.T) {
+	db, raw := testPostgres(t)
+	err := db.StartBulkLoad(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// batches of 3 so blocks before the last in a tx go through AddBlockHeader while the COPY is open
+	pi := NewParallelImporter(db, 2, 3)
+	for round := uint64(0); round < 7; round++ {
+		err = pi.ImportBlock(testBlock(round, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = pi.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.EndBulkLoad()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxRound, err := db.GetMaxRound()
+	if err != nil || maxRound != 6 {
+		t.Fatalf("max round %d %v, want 6", maxRound, err)
+	}
+	progress, err := db.GetImportProgress()
+	if err != nil || progress.ContiguousRound != 6 {
+		t.Fatalf("contiguous round %d %v, want 6", progress.ContiguousRound, err)
+	}
+	var txns, participation, headers int
+	err = raw.QueryRow(`SELECT (SELECT count(*) FROM txn), (SELECT count(*) FROM txn_participation), (SELECT count(*) FROM block_header)`).Scan(&txns, &participation, &headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txns != 14 || participation != 28 || headers != 7 {
+		t.Fatalf("%d txns, %d txn_participation, %d block_header; want 14, 28, 7", txns, participation, headers)
+	}
+	block, err := db.GetBlock(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Payset) != 2 || block.RewardsLevel != 4 {
+		t.Fatalf("round 4 has %d txns, rewards level %d", len(block.Payset), block.RewardsLevel)
+	}
+}
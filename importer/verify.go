@@ -0,0 +1,156 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"crypto/sha512"
+	"database/sql"
+	"fmt"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+// hashObj is go-algorand crypto.HashObj(), sha512/256 of a domain separation prefix and the canonical msgpack encoding
+func hashObj(prefix string, obj interface{}) types.Digest {
+	return types.Digest(sha512.Sum512_256(append([]byte(prefix), msgpack.Encode(obj)...)))
+}
+
+// BlockHeaderHash is what the next block's Branch should be
+func BlockHeaderHash(header types.BlockHeader) types.BlockHash {
+	return types.BlockHash(hashObj("BH", header))
+}
+
+// GenesisHash is the hash of a genesis.json as put in every block
+func GenesisHash(genesis types.Genesis) types.Digest {
+	return hashObj("GE", genesis)
+}
+
+// GenesisID is the network name as put in every block
+func GenesisID(genesis types.Genesis) string {
+	return genesis.Network + "-" + genesis.SchemaID
+}
+
+// paysetCommit is go-algorand Payset.CommitFlat(), the TxnRoot of every protocol version this indexer decodes.
+// Those protocols commit to the whole payset as it is encoded in the block, ApplyData and the hgi/hgh flags
+// included, not to a merkle tree of txids; the merkle commitment (PaysetCommitMerkle) only came with later
+// protocols whose blocks these types don't cover.
+func paysetCommit(block types.Block) types.Digest {
+	payset := block.Payset
+	if len(payset) == 0 {
+		if block.Round == 0 {
+			// the genesis block commits to an empty but non-nil payset
+			payset = types.Payset{}
+		} else {
+			payset = nil
+		}
+	}
+	return hashObj("PF", payset)
+}
+
+func verifyTxnRoot(block types.Block) error {
+	commit := paysetCommit(block)
+	if commit != block.TxnRoot {
+		return fmt.Errorf("round %d: txn root %x does not match payset commitment %x", block.Round, block.TxnRoot[:], commit[:])
+	}
+	return nil
+}
+
+// BlockVerifier checks that blocks chain onto the previous stored block header and belong to the expected network.
+// Blocks must be given to it in the order they are written.
+type BlockVerifier struct {
+	db idb.IndexerDb
+
+	genesisID   string
+	genesisHash types.Digest
+	haveGenesis bool
+
+	prevRound uint64
+	prevHash  types.BlockHash
+	havePrev  bool
+}
+
+// NewBlockVerifier checks blocks against genesis if not nil, otherwise against the most recent block already in the db.
+func NewBlockVerifier(db idb.IndexerDb, genesis *types.Genesis) (bv *BlockVerifier, err error) {
+	bv = &BlockVerifier{db: db}
+	if genesis != nil {
+		bv.genesisID = GenesisID(*genesis)
+		bv.genesisHash = GenesisHash(*genesis)
+		bv.haveGenesis = true
+		return
+	}
+	maxRound, err := db.GetMaxRound()
+	if err != nil || maxRound < 0 {
+		return
+	}
+	block, err := db.GetBlock(uint64(maxRound))
+	if err != nil {
+		return nil, fmt.Errorf("getting block %d, %v", maxRound, err)
+	}
+	bv.genesisID = block.GenesisID
+	bv.genesisHash = block.GenesisHash
+	bv.haveGenesis = true
+	return
+}
+
+// prevBlockHash finds the hash of the header before round, from the last verified block or the db.
+func (bv *BlockVerifier) prevBlockHash(round uint64) (hash types.BlockHash, ok bool, err error) {
+	if bv.havePrev && bv.prevRound+1 == round {
+		return bv.prevHash, true, nil
+	}
+	prev, err := bv.db.GetBlock(round - 1)
+	if err == sql.ErrNoRows {
+		// nothing to check against
+		return hash, false, nil
+	}
+	if err != nil {
+		return
+	}
+	return BlockHeaderHash(prev.BlockHeader), true, nil
+}
+
+// Verify checks a block header, the payset is checked by verifyTxnRoot()
+func (bv *BlockVerifier) Verify(header types.BlockHeader) error {
+	round := uint64(header.Round)
+	if !bv.haveGenesis {
+		// first block into an empty db sets the network
+		bv.genesisID = header.GenesisID
+		bv.genesisHash = header.GenesisHash
+		bv.haveGenesis = true
+	}
+	if header.GenesisID != bv.genesisID {
+		return fmt.Errorf("round %d: genesis id %#v, expected %#v", round, header.GenesisID, bv.genesisID)
+	}
+	if header.GenesisHash != bv.genesisHash {
+		return fmt.Errorf("round %d: genesis hash %x, expected %x", round, header.GenesisHash[:], bv.genesisHash[:])
+	}
+	if round > 0 {
+		prevHash, ok, err := bv.prevBlockHash(round)
+		if err != nil {
+			return fmt.Errorf("round %d: getting previous block header, %v", round, err)
+		}
+		if ok && prevHash != header.Branch {
+			return fmt.Errorf("round %d: branch %x does not match hash of round %d header %x", round, header.Branch[:], round-1, prevHash[:])
+		}
+	}
+	bv.prevRound = round
+	bv.prevHash = BlockHeaderHash(header)
+	bv.havePrev = true
+	return nil
+}
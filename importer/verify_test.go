@@ -0,0 +1,159 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/encoding/json"
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+
+	"github.com/algorand/indexer/types"
+)
+
+// testdata holds a genesis.json and rounds 0 and 1 of the network it starts,
+// with one signed payment in round 1.
+func readFixture(t *testing.T) (genesis types.Genesis, blocks [2]types.Block) {
+	gbytes, err := ioutil.ReadFile(filepath.Join("testdata", "genesis.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Decode(gbytes, &genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := range blocks {
+		blockbytes, err := ioutil.ReadFile(filepath.Join("testdata", "blocks", strconv.Itoa(round)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bc types.EncodedBlockCert
+		err = msgpack.Decode(blockbytes, &bc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[round] = bc.Block
+	}
+	return
+}
+
+// the fixture's hashes, so that a change to how types encode shows up here
+// and not as every imported block failing to verify
+const (
+	fixtureGenesisHash = "b4a604aa3b0988436b3b45a4e5d9605d3bd2c6f542c761bdff48b62b669f91ed"
+	fixtureRound0Hash  = "0fd726596d1ababafccc7aa2e44ff8ffd8df1bcf1e1c51a3fb0a0065aa0a96d4"
+	// sha512/256("PF" 0x90), the genesis block's empty payset
+	fixtureRound0Root = "277862b1b2d2d1279bb5a19d0d87518fe71500f126b8ba336775bd349a1e7b73"
+	fixtureRound1Root = "7ded0b5a8c699358128f16a889ce304a9a756a292dab9b862affb1b51d27ecd1"
+)
+
+func TestVerifyFixture(t *testing.T) {
+	genesis, blocks := readFixture(t)
+
+	gh := GenesisHash(genesis)
+	if hex.EncodeToString(gh[:]) != fixtureGenesisHash || gh != blocks[0].GenesisHash {
+		t.Errorf("genesis hash %x, blocks have %x", gh[:], blocks[0].GenesisHash[:])
+	}
+	if GenesisID(genesis) != blocks[0].GenesisID {
+		t.Errorf("genesis id %s, blocks have %s", GenesisID(genesis), blocks[0].GenesisID)
+	}
+	bh := BlockHeaderHash(blocks[0].BlockHeader)
+	if hex.EncodeToString(bh[:]) != fixtureRound0Hash || bh != blocks[1].Branch {
+		t.Errorf("round 0 header hash %x, round 1 branch %x", bh[:], blocks[1].Branch[:])
+	}
+	for round, want := range []string{fixtureRound0Root, fixtureRound1Root} {
+		root := paysetCommit(blocks[round])
+		if hex.EncodeToString(root[:]) != want {
+			t.Errorf("round %d payset commitment %x", round, root[:])
+		}
+	}
+
+	bv, err := NewBlockVerifier(nil, &genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		err = verifyTxnRoot(block)
+		if err != nil {
+			t.Error(err)
+		}
+		err = bv.Verify(block.BlockHeader)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(genesis *types.Genesis, blocks *[2]types.Block)
+		err    string
+	}{
+		{
+			"payment amount",
+			func(genesis *types.Genesis, blocks *[2]types.Block) { blocks[1].Payset[0].Txn.Amount++ },
+			"round 1: txn root",
+		},
+		{
+			"apply data",
+			func(genesis *types.Genesis, blocks *[2]types.Block) { blocks[1].Payset[0].SenderRewards = 1 },
+			"round 1: txn root",
+		},
+		{
+			"previous header",
+			func(genesis *types.Genesis, blocks *[2]types.Block) { blocks[0].TimeStamp++ },
+			"round 1: branch",
+		},
+		{
+			"genesis allocation",
+			func(genesis *types.Genesis, blocks *[2]types.Block) { genesis.Allocation[0].State.MicroAlgos++ },
+			"round 0: genesis hash",
+		},
+		{
+			"network",
+			func(genesis *types.Genesis, blocks *[2]types.Block) { blocks[0].GenesisID = "mainnet-v1.0" },
+			"round 0: genesis id",
+		},
+	}
+	for _, tc := range tests {
+		genesis, blocks := readFixture(t)
+		tc.tamper(&genesis, &blocks)
+		bv, err := NewBlockVerifier(nil, &genesis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, block := range blocks {
+			// through decodeBlock as the importers do
+			_, err = decodeBlock(msgpack.Encode(types.EncodedBlockCert{Block: block}), true)
+			if err == nil {
+				err = bv.Verify(block.BlockHeader)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %s...", tc.name, err, tc.err)
+		}
+	}
+}
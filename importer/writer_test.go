@@ -0,0 +1,215 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	atypes "github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/algorand/indexer/idb"
+	"github.com/algorand/indexer/types"
+)
+
+func testBlock(round uint64, ntxns int) []byte {
+	var block types.EncodedBlockCert
+	block.Block.Round = types.Round(round)
+	block.Block.TimeStamp = 1500000000 + int64(round)
+	block.Block.RewardsLevel = round
+	for i := 0; i < ntxns; i++ {
+		var stxn types.SignedTxnInBlock
+		stxn.Txn.Type = atypes.PaymentTx
+		stxn.Txn.Sender[0] = byte(i + 1)
+		stxn.Txn.Receiver[0] = byte(i + 100)
+		stxn.Txn.Amount = atypes.MicroAlgos(round*10 + uint64(i))
+		stxn.Txn.FirstValid = atypes.Round(round)
+		stxn.Txn.LastValid = atypes.Round(round + 1000)
+		block.Block.Payset = append(block.Block.Payset, stxn)
+	}
+	return msgpack.Encode(block)
+}
+
+// batchDb records the rounds of each StartBlock()...CommitBlock() transaction
+type batchDb struct {
+	idb.IndexerDb
+
+	// failRound fails adding its header
+	failRound uint64
+	fail      bool
+
+	l       sync.Mutex
+	open    []uint64
+	batches [][]uint64
+	txns    int
+}
+
+func (db *batchDb) GetImportProgress() (idb.ImportProgress, error) {
+	return idb.ImportProgress{ContiguousRound: -1}, nil
+}
+
+func (db *batchDb) HasBlock(round uint64) (bool, error) {
+	return false, nil
+}
+
+func (db *batchDb) StartBlock() error {
+	db.l.Lock()
+	defer db.l.Unlock()
+	db.open = nil
+	return nil
+}
+
+func (db *batchDb) AddTransaction(round uint64, intra int, txtypeenum int, assetid uint64, txnbytes []byte, txn types.SignedTxnInBlock, participation [][]byte) error {
+	db.l.Lock()
+	defer db.l.Unlock()
+	db.txns++
+	return nil
+}
+
+func (db *batchDb) AddBlockHeader(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
+	if db.fail && round == db.failRound {
+		return fmt.Errorf("no room for round %d", round)
+	}
+	db.l.Lock()
+	defer db.l.Unlock()
+	db.open = append(db.open, round)
+	return nil
+}
+
+func (db *batchDb) CommitBlock(round uint64, timestamp int64, rewardslevel uint64, headerbytes []byte) error {
+	err := db.AddBlockHeader(round, timestamp, rewardslevel, headerbytes)
+	if err != nil {
+		return err
+	}
+	// slow enough that a Flush which didn't wait would see this batch missing
+	time.Sleep(2 * time.Millisecond)
+	db.l.Lock()
+	defer db.l.Unlock()
+	db.batches = append(db.batches, db.open)
+	db.open = nil
+	return nil
+}
+
+func (db *batchDb) written() [][]uint64 {
+	db.l.Lock()
+	defer db.l.Unlock()
+	return append([][]uint64(nil), db.batches...)
+}
+
+// importBlocks gives the importer rounds [start, end), bigger blocks for even rounds so that workers finish out of order
+func importBlocks(t *testing.T, pi *ParallelImporter, start, end uint64) {
+	for round := start; round < end; round++ {
+		ntxns := 1
+		if round%2 == 0 {
+			ntxns = 200
+		}
+		err := pi.ImportBlock(testBlock(round, ntxns))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParallelImporterOrderAndBatches(t *testing.T) {
+	db := &batchDb{}
+	pi := NewParallelImporter(db, 4, 3)
+	importBlocks(t, pi, 0, 5)
+	err := pi.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a flush ends the batch early
+	want := [][]uint64{{0, 1, 2}, {3, 4}}
+	if got := db.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after flush wrote %v, want %v", got, want)
+	}
+	importBlocks(t, pi, 5, 12)
+	err = pi.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, []uint64{5, 6, 7}, []uint64{8, 9, 10}, []uint64{11})
+	if got := db.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrote %v, want %v", got, want)
+	}
+	stats := pi.Stats()
+	if stats.Blocks != 12 || stats.Txns != db.txns || db.txns != 6*200+6 {
+		t.Errorf("stats %d blocks %d txns, db has %d txns", stats.Blocks, stats.Txns, db.txns)
+	}
+}
+
+func TestParallelImporterStopsAtError(t *testing.T) {
+	tests := []struct {
+		name string
+		db   *batchDb
+		// the block at this position doesn't decode
+		badBlock int
+		want     [][]uint64
+		err      string
+	}{
+		{"db", &batchDb{fail: true, failRound: 4}, -1, [][]uint64{{0, 1, 2}}, "error adding block header r=4, no room for round 4"},
+		{"db on commit", &batchDb{fail: true, failRound: 5}, -1, [][]uint64{{0, 1, 2}}, "error committing block, no room for round 5"},
+		{"decode", &batchDb{}, 4, [][]uint64{{0, 1, 2}}, "error decoding blockbytes"},
+	}
+	for _, tc := range tests {
+		pi := NewParallelImporter(tc.db, 4, 3)
+		var err error
+		for round := 0; round < 40 && err == nil; round++ {
+			blockbytes := testBlock(uint64(round), 1)
+			if round == tc.badBlock {
+				blockbytes = []byte("not a block")
+			}
+			err = pi.ImportBlock(blockbytes)
+		}
+		closeErr := pi.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %s", tc.name, err, tc.err)
+		}
+		if closeErr == nil || closeErr.Error() != err.Error() {
+			t.Errorf("%s: Close returned %v, ImportBlock %v", tc.name, closeErr, err)
+		}
+		if got := tc.db.written(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: wrote %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParallelImporterFlushReturnsError(t *testing.T) {
+	db := &batchDb{fail: true, failRound: 1}
+	pi := NewParallelImporter(db, 2, 10)
+	importBlocks(t, pi, 0, 3)
+	err := pi.Flush()
+	if err == nil || !strings.Contains(err.Error(), "no room for round 1") {
+		t.Errorf("flush returned %v", err)
+	}
+	err = pi.ImportBlock(testBlock(3, 1))
+	if err == nil {
+		t.Error("import after a failed flush accepted")
+	}
+	pi.Close()
+	if got := db.written(); len(got) != 0 {
+		t.Errorf("wrote %v", got)
+	}
+}
@@ -20,7 +20,8 @@ def main():
     for x in blocknums:
         if prev is not None and (prev != (x - 1)):
             print("bad sequence: prev = {}, cur = {}".format(prev, x))
-            stop = Prev
+            stop = x
+            break
         prev = x
 
     batch = []
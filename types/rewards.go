@@ -0,0 +1,30 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package types
+
+// RewardUnit is config.ConsensusParams.RewardUnit, the same in every protocol so far
+const RewardUnit = 1000000
+
+// PendingRewards is the rewards an account has earned since rewardsBase but which aren't in its MicroAlgos yet.
+// Ported from go-algorand data/basics/userBalance.go AccountData.WithUpdatedRewards()
+func PendingRewards(status byte, microalgos MicroAlgos, rewardsBase, rewardsLevel uint64) MicroAlgos {
+	if status == NotParticipating || rewardsLevel < rewardsBase {
+		return 0
+	}
+	rewardUnits := uint64(microalgos) / RewardUnit
+	return MicroAlgos(rewardUnits * (rewardsLevel - rewardsBase))
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/algod/models"
+)
+
+func TestPendingRewards(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       byte
+		microalgos   MicroAlgos
+		rewardsBase  uint64
+		rewardsLevel uint64
+		want         MicroAlgos
+	}{
+		{"online", Online, 5 * RewardUnit, 10, 13, 15},
+		{"offline", Offline, 5 * RewardUnit, 10, 13, 15},
+		{"not participating", NotParticipating, 5 * RewardUnit, 10, 13, 0},
+		{"partial reward unit", Online, 5*RewardUnit + RewardUnit - 1, 10, 13, 15},
+		{"less than a reward unit", Offline, RewardUnit - 1, 10, 13, 0},
+		{"no new rewards", Online, 5 * RewardUnit, 13, 13, 0},
+		{"base above level", Online, 5 * RewardUnit, 14, 13, 0},
+		{"not participating base above level", NotParticipating, 5 * RewardUnit, 14, 13, 0},
+		{"from genesis", Online, 2 * RewardUnit, 0, 1000, 2000},
+	}
+	for _, tc := range tests {
+		got := PendingRewards(tc.status, tc.microalgos, tc.rewardsBase, tc.rewardsLevel)
+		if got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+// algodAccountTests are accounts as algod /v1/account reports them, paired with the rewards level of
+// the block at that round and the account's rewardsbase from its msgpack record,
+// which /v1/account doesn't report.
+var algodAccountTests = []struct {
+	name         string
+	account      string
+	rewardsBase  uint64
+	rewardsLevel uint64
+}{
+	{
+		"online",
+		`{"round":6000000,"address":"CSCHPSN3P7B6K6QGVDYAFLF4VZCZC5EXJZF4RX7Y6G5TLDGQGQCDFUCWRE","amount":1235028383614,"pendingrewards":460493491,"amountwithoutpendingrewards":1234567890123,"rewards":9876543210,"status":"Online"}`,
+		21500, 21873,
+	},
+	{
+		"offline, partial reward unit",
+		`{"round":6000000,"address":"GD64YIY3TWGDMCNPP553DZPPR6LDUSFQOIJVFDPPXWEG3FVOJCCDBBHU5A","amount":12500036,"pendingrewards":36,"amountwithoutpendingrewards":12500000,"rewards":1024,"status":"Offline"}`,
+		21870, 21873,
+	},
+	{
+		"not participating",
+		`{"round":6000000,"address":"737777777777777777777777777777777777777777777777777UFEJ2CI","amount":2500000000000000,"pendingrewards":0,"amountwithoutpendingrewards":2500000000000000,"rewards":0,"status":"Not Participating"}`,
+		0, 21873,
+	},
+	{
+		"rewards just paid out",
+		`{"round":6000000,"address":"CSCHPSN3P7B6K6QGVDYAFLF4VZCZC5EXJZF4RX7Y6G5TLDGQGQCDFUCWRE","amount":5000000,"pendingrewards":0,"amountwithoutpendingrewards":5000000,"rewards":57,"status":"Online"}`,
+		21873, 21873,
+	},
+}
+
+func TestPendingRewardsMatchesAlgod(t *testing.T) {
+	for _, tc := range algodAccountTests {
+		var account models.Account
+		err := json.Unmarshal([]byte(tc.account), &account)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		status := byte(255)
+		for _, s := range []byte{Offline, Online, NotParticipating} {
+			if StatusString(s) == account.Status {
+				status = s
+			}
+		}
+		if status == 255 {
+			t.Fatalf("%s: unknown status %s", tc.name, account.Status)
+		}
+		got := PendingRewards(status, MicroAlgos(account.AmountWithoutPendingRewards), tc.rewardsBase, tc.rewardsLevel)
+		if uint64(got) != account.PendingRewards {
+			t.Errorf("%s: got %d, algod says %d", tc.name, got, account.PendingRewards)
+		}
+		if account.AmountWithoutPendingRewards+uint64(got) != account.Amount {
+			t.Errorf("%s: %d+%d != amount %d", tc.name, account.AmountWithoutPendingRewards, got, account.Amount)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"crypto/sha512"
+	"encoding/base32"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+)
+
+// TransactionIDString is the txid algod shows, the pinned sdk's crypto doesn't export it
+func TransactionIDString(txn Transaction) string {
+	h := sha512.Sum512_256(append([]byte("TX"), msgpack.Encode(txn)...))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h[:])
+}
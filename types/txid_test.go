@@ -0,0 +1,59 @@
+// Copyright (C) 2019-2020 Algorand, Inc.
+// This file is part of the Algorand Indexer
+//
+// Algorand Indexer is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Algorand Indexer is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with Algorand Indexer.  If not, see <https://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	atypes "github.com/algorand/go-algorand-sdk/types"
+)
+
+// a nonparticipating keyreg as algod encodes it, "nonpart" isn't in the pinned sdk
+func TestNonparticipationRoundTrip(t *testing.T) {
+	var st atypes.SignedTxn
+	st.Txn.Type = atypes.KeyRegistrationTx
+	st.Txn.Sender[0] = 3
+	st.Txn.Fee = 1000
+	st.Sig[0] = 9
+	var withNonpart SignedTxnInBlock
+	err := msgpack.Decode(msgpack.Encode(st), &withNonpart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msgpack.Encode(withNonpart.SignedTxn), msgpack.Encode(st)) {
+		t.Fatal("txn without nonpart encodes differently from the sdk")
+	}
+	withNonpart.Txn.Nonparticipation = true
+	txnbytes := msgpack.Encode(withNonpart)
+
+	var decoded SignedTxnInBlock
+	err = msgpack.Decode(txnbytes, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Txn.Nonparticipation {
+		t.Fatal("nonpart lost in decoding")
+	}
+	if !bytes.Equal(msgpack.Encode(decoded), txnbytes) {
+		t.Fatal("re-encoding changed the txn")
+	}
+	if TransactionIDString(decoded.Txn) == TransactionIDString(Transaction{Transaction: st.Txn}) {
+		t.Fatal("nonpart not part of the txid")
+	}
+}
@@ -201,10 +201,20 @@ type (
 	SignedTxnWithAD struct {
 		_struct struct{} `codec:",omitempty,omitemptyarray"`
 
-		atypes.SignedTxn
+		SignedTxn
 		ApplyData
 	}
 
+	// SignedTxn is the sdk's SignedTxn with this package's Transaction
+	SignedTxn struct {
+		_struct struct{} `codec:",omitempty,omitemptyarray"`
+
+		Sig  atypes.Signature   `codec:"sig"`
+		Msig atypes.MultisigSig `codec:"msig"`
+		Lsig atypes.LogicSig    `codec:"lsig"`
+		Txn  Transaction        `codec:"txn"`
+	}
+
 	/*
 		MultisigSig struct {
 			_struct struct{} `codec:",omitempty,omitemptyarray"`
@@ -253,7 +263,18 @@ type (
 		CloseRewards    MicroAlgos `codec:"rc"`
 	}
 
-	Transaction = atypes.Transaction
+	// Transaction is the sdk's Transaction plus the fields the pinned sdk doesn't have.
+	// Without them a block that uses them wouldn't decode, and re-encoding a txn would change its txid and the payset commitment.
+	Transaction struct {
+		_struct struct{} `codec:",omitempty,omitemptyarray"`
+
+		atypes.Transaction
+
+		// Nonparticipation is part of KeyregTxnFields, it marks the sender as never participating again
+		Nonparticipation bool `codec:"nonpart"`
+	}
+	// KeyregTxnFields is part of Transaction
+	KeyregTxnFields = atypes.KeyregTxnFields
 	/*
 			Transaction struct {
 				_struct struct{} `codec:",omitempty,omitemptyarray"`
@@ -644,3 +665,27 @@ type (
 		Frozen bool   `codec:"f"`
 	}
 )
+
+// AccountData.Status values, from github.com/algorand/go-algorand/data/basics/userBalance.go
+const (
+	// Offline indicates that the associated account is delegated.
+	Offline byte = 0
+	// Online indicates that the associated account used as part of the delegation pool.
+	Online byte = 1
+	// NotParticipating indicates that the associated account is neither a delegator nor a delegate.
+	NotParticipating byte = 2
+)
+
+// StatusString is go-algorand basics.Status.String() as algod reports it
+func StatusString(status byte) string {
+	switch status {
+	case Offline:
+		return "Offline"
+	case Online:
+		return "Online"
+	case NotParticipating:
+		return "Not Participating"
+	default:
+		return "Unknown"
+	}
+}